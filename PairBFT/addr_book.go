@@ -0,0 +1,258 @@
+package PairBFT
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	"github.com/Nik-U/pbc"
+)
+
+var (
+	ErrInvalidAddrRecord = errors.New("invalid address record")
+)
+
+// An AddrRecord announces where a validator can be reached. It is signed with
+// the validator's BLS key; a record replaces an older one of the same
+// validator if its sequence number is higher.
+type (
+	AddrRecord struct {
+		ValIndex uint32
+		Seq      uint64
+		Addrs    []string
+		Sig      *pbc.Element
+	}
+
+	AddrBook struct {
+		mutex   sync.RWMutex
+		records []*AddrRecord
+	}
+
+	addrRecordJSON struct {
+		ValIndex uint32   `json:"index"`
+		Seq      uint64   `json:"seq"`
+		Addrs    []string `json:"addrs"`
+		Sig      string   `json:"sig"`
+	}
+)
+
+const (
+	lenAddrIndex = 4
+	lenAddrSeq   = 8
+	lenAddrLen   = 2
+	MaxAddrs     = 8
+)
+
+func (rec *AddrRecord) body() []byte {
+	l := lenAddrIndex + lenAddrSeq + 1
+	for _, addr := range rec.Addrs {
+		l += lenAddrLen + len(addr)
+	}
+	b := make([]byte, l)
+	i := 0
+	binary.LittleEndian.PutUint32(b[i:], rec.ValIndex)
+	i += lenAddrIndex
+	binary.LittleEndian.PutUint64(b[i:], rec.Seq)
+	i += lenAddrSeq
+	b[i] = byte(len(rec.Addrs))
+	i++
+	for _, addr := range rec.Addrs {
+		binary.LittleEndian.PutUint16(b[i:], uint16(len(addr)))
+		i += lenAddrLen
+		copy(b[i:], addr)
+		i += len(addr)
+	}
+	return b
+}
+
+func (rec *AddrRecord) hash() []byte {
+	h := sha256.Sum256(rec.body())
+	return getNoncedHash(h[:], NonceAddrRecord)
+}
+
+func (rec *AddrRecord) Sign(bls *BLS, privKey *pbc.Element) {
+	rec.Sig = bls.SignHash(rec.hash(), privKey)
+}
+
+func (rec *AddrRecord) Verify(bls *BLS, pubKeys []*pbc.Element) bool {
+	if int(rec.ValIndex) >= len(pubKeys) || len(rec.Addrs) == 0 || len(rec.Addrs) > MaxAddrs || rec.Sig == nil {
+		return false
+	}
+	return bls.VerifyHash(rec.hash(), rec.Sig, pubKeys[rec.ValIndex])
+}
+
+func (rec *AddrRecord) Bytes() []byte {
+	body := rec.body()
+	sig := rec.Sig.Bytes()
+	b := make([]byte, LenMsgType+len(body)+len(sig))
+	b[0] = MsgTypeAddrRecord
+	copy(b[LenMsgType:], body)
+	copy(b[LenMsgType+len(body):], sig)
+	return b
+}
+
+func (rec *AddrRecord) SetBytes(bls *BLS, b []byte) error {
+	i := LenMsgType
+	if len(b) < i+lenAddrIndex+lenAddrSeq+1 || b[0] != MsgTypeAddrRecord {
+		return ErrInvalidAddrRecord
+	}
+	rec.ValIndex = binary.LittleEndian.Uint32(b[i:])
+	i += lenAddrIndex
+	rec.Seq = binary.LittleEndian.Uint64(b[i:])
+	i += lenAddrSeq
+	numAddrs := int(b[i])
+	i++
+	if numAddrs == 0 || numAddrs > MaxAddrs {
+		return ErrInvalidAddrRecord
+	}
+	rec.Addrs = make([]string, numAddrs)
+	for j := 0; j < numAddrs; j++ {
+		if len(b) < i+lenAddrLen {
+			return ErrInvalidAddrRecord
+		}
+		l := int(binary.LittleEndian.Uint16(b[i:]))
+		i += lenAddrLen
+		if len(b) < i+l {
+			return ErrInvalidAddrRecord
+		}
+		rec.Addrs[j] = string(b[i : i+l])
+		i += l
+	}
+	rec.Sig = bls.pairing.NewG1()
+	if len(b)-i != rec.Sig.BytesLen() {
+		return ErrInvalidAddrRecord
+	}
+	rec.Sig.SetBytes(b[i:])
+	return nil
+}
+
+// Init fills the book with unsigned records of sequence number 0, which any
+// signed record replaces.
+func (book *AddrBook) Init(addrs []string) {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.records = make([]*AddrRecord, len(addrs))
+	for i, addr := range addrs {
+		book.records[i] = &AddrRecord{ValIndex: uint32(i), Addrs: []string{addr}}
+	}
+}
+
+func (book *AddrBook) Len() int {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	return len(book.records)
+}
+
+func (book *AddrBook) Addr(peer int) string {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	if peer < 0 || peer >= len(book.records) {
+		return ""
+	}
+	return book.records[peer].Addrs[0]
+}
+
+func (book *AddrBook) Index(addr string) int {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	for i, rec := range book.records {
+		for _, a := range rec.Addrs {
+			if a == addr {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func (book *AddrBook) Record(peer int) *AddrRecord {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	if peer < 0 || peer >= len(book.records) {
+		return nil
+	}
+	return book.records[peer]
+}
+
+// IsNewer tells whether rec would replace the known record of its validator,
+// without verifying its signature.
+func (book *AddrBook) IsNewer(rec *AddrRecord) bool {
+	book.mutex.RLock()
+	defer book.mutex.RUnlock()
+	return book.isNewer(rec)
+}
+
+func (book *AddrBook) isNewer(rec *AddrRecord) bool {
+	return int(rec.ValIndex) < len(book.records) && rec.Seq > book.records[rec.ValIndex].Seq
+}
+
+// Update stores rec if it is newer than the known record and correctly
+// signed. Stale records are rejected before the signature is checked.
+func (book *AddrBook) Update(rec *AddrRecord, bls *BLS, pubKeys []*pbc.Element) bool {
+	if !book.IsNewer(rec) || !rec.Verify(bls, pubKeys) {
+		return false
+	}
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	if !book.isNewer(rec) {
+		return false
+	}
+	book.records[rec.ValIndex] = rec
+	return true
+}
+
+// Save writes the signed records to fileName, so that a restarted validator
+// starts with the last known addresses.
+func (book *AddrBook) Save(fileName string) error {
+	book.mutex.RLock()
+	records := make([]addrRecordJSON, 0, len(book.records))
+	for _, rec := range book.records {
+		if rec.Sig == nil {
+			continue
+		}
+		records = append(records, addrRecordJSON{rec.ValIndex, rec.Seq, rec.Addrs, hex.EncodeToString(rec.Sig.Bytes())})
+	}
+	book.mutex.RUnlock()
+
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	tempName := fileName + ".tmp"
+	if err = ioutil.WriteFile(tempName, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tempName, fileName)
+}
+
+// Load merges the records saved in fileName into the book. Records are
+// verified again, so a tampered file cannot redirect traffic; malformed or
+// invalid records are skipped.
+func (book *AddrBook) Load(fileName string, bls *BLS, pubKeys []*pbc.Element) error {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return err
+	}
+	var records []addrRecordJSON
+	if err = json.Unmarshal(data, &records); err != nil {
+		return err
+	}
+	for _, r := range records {
+		sig, err := hex.DecodeString(r.Sig)
+		if err != nil {
+			continue
+		}
+		rec := &AddrRecord{ValIndex: r.ValIndex, Seq: r.Seq, Addrs: r.Addrs, Sig: bls.pairing.NewG1()}
+		if len(sig) != rec.Sig.BytesLen() {
+			continue
+		}
+		rec.Sig.SetBytes(sig)
+		book.Update(rec, bls, pubKeys)
+	}
+	return nil
+}
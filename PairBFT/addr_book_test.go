@@ -0,0 +1,86 @@
+package PairBFT
+
+import (
+	"github.com/Nik-U/pbc"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddrRecordUpdate(t *testing.T) {
+	numVals := 4
+	bls := &BLS{}
+	bls.Init()
+
+	privKeys := make([]*pbc.Element, numVals)
+	pubKeys := make([]*pbc.Element, numVals)
+	for i := 0; i < numVals; i++ {
+		privKeys[i], pubKeys[i] = bls.GenKey()
+	}
+	book := &AddrBook{}
+	book.Init(genLocalValidatorAddresses(numVals))
+
+	rec := &AddrRecord{ValIndex: 2, Seq: 5, Addrs: []string{"10.0.0.2:3000", "10.0.1.2:3000"}}
+	rec.Sign(bls, privKeys[2])
+
+	rec2 := &AddrRecord{}
+	if err := rec2.SetBytes(bls, rec.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if !book.Update(rec2, bls, pubKeys) {
+		t.Error("Valid record rejected.")
+	}
+	if book.Addr(2) != "10.0.0.2:3000" || book.Index("10.0.1.2:3000") != 2 {
+		t.Error("Address book not updated.")
+	}
+
+	old := &AddrRecord{ValIndex: 2, Seq: 4, Addrs: []string{"10.0.0.9:3000"}}
+	old.Sign(bls, privKeys[2])
+	if book.Update(old, bls, pubKeys) {
+		t.Error("Older record accepted.")
+	}
+
+	forged := &AddrRecord{ValIndex: 2, Seq: 6, Addrs: []string{"10.0.0.9:3000"}}
+	forged.Sign(bls, privKeys[1])
+	if book.Update(forged, bls, pubKeys) {
+		t.Error("Record signed by another validator accepted.")
+	}
+
+	dir, err := ioutil.TempDir("", "addrbook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	fileName := filepath.Join(dir, "addrbook.json")
+	if err = book.Save(fileName); err != nil {
+		t.Fatal(err)
+	}
+	book2 := &AddrBook{}
+	book2.Init(genLocalValidatorAddresses(numVals))
+	if err = book2.Load(fileName, bls, pubKeys); err != nil {
+		t.Fatal(err)
+	}
+	if book2.Addr(2) != "10.0.0.2:3000" || book2.Record(2).Seq != 5 {
+		t.Error("Saved record not restored.")
+	}
+
+	// Bad entries of a tampered file are skipped, the others still load
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := `[{"index": 1, "seq": 9, "addrs": ["10.0.0.9:3000"], "sig": "zz"},
+		{"index": 1, "seq": 9, "addrs": ["10.0.0.9:3000"], "sig": "00"},` + string(data[1:])
+	if err = ioutil.WriteFile(fileName, []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+	book3 := &AddrBook{}
+	book3.Init(genLocalValidatorAddresses(numVals))
+	if err = book3.Load(fileName, bls, pubKeys); err != nil {
+		t.Fatal(err)
+	}
+	if book3.Addr(1) != genLocalValidatorAddresses(numVals)[1] || book3.Addr(2) != "10.0.0.2:3000" {
+		t.Error("Tampered file not handled.")
+	}
+}
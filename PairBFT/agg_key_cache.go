@@ -0,0 +1,171 @@
+package PairBFT
+
+import (
+	"container/list"
+	"encoding/binary"
+	"sync"
+
+	"github.com/Nik-U/pbc"
+)
+
+// AggKeyCache remembers the aggregated public keys of recently verified
+// counter vectors. A key that is not cached is derived from the cached key
+// with the most signers in common, or from the key of the full validator set
+// when only a few validators are missing, so that consecutive aggregates of
+// one phase cost a handful of multiplications instead of one per signer.
+// Keys returned by the cache are shared and must not be modified.
+type (
+	AggKeyCache struct {
+		mutex   sync.Mutex
+		bls     *BLS
+		pubKeys []*pbc.Element
+		fullKey *pbc.Element
+		size    int
+		entries map[string]*list.Element
+		lru     *list.List
+	}
+
+	aggKeyEntry struct {
+		id       string
+		counters []uint32
+		key      *pbc.Element
+	}
+)
+
+const (
+	AggKeyCacheSize = 64
+)
+
+func (c *AggKeyCache) Init(bls *BLS, pubKeys []*pbc.Element, size int) {
+	c.bls = bls
+	c.pubKeys = pubKeys
+	c.size = size
+	c.entries = make(map[string]*list.Element)
+	c.lru = list.New()
+
+	c.fullKey = bls.pairing.NewG2()
+	for _, pubKey := range pubKeys {
+		c.fullKey.ThenMul(pubKey)
+	}
+}
+
+func countersID(counters []uint32) string {
+	b := make([]byte, lenCounter*len(counters))
+	for i, counter := range counters {
+		binary.LittleEndian.PutUint32(b[i*lenCounter:], counter)
+	}
+	return string(b)
+}
+
+func (c *AggKeyCache) FullKey() *pbc.Element {
+	return c.fullKey
+}
+
+func (c *AggKeyCache) AggKey(counters []uint32) *pbc.Element {
+	id := countersID(counters)
+
+	c.mutex.Lock()
+	if e, ok := c.entries[id]; ok {
+		c.lru.MoveToFront(e)
+		key := e.Value.(*aggKeyEntry).key
+		c.mutex.Unlock()
+		return key
+	}
+	base := c.findBase(counters)
+	c.mutex.Unlock()
+
+	key := c.derive(counters, base)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[id]; !ok {
+		entry := &aggKeyEntry{id: id, counters: append([]uint32(nil), counters...), key: key}
+		c.entries[id] = c.lru.PushFront(entry)
+		for c.lru.Len() > c.size {
+			oldest := c.lru.Back()
+			delete(c.entries, oldest.Value.(*aggKeyEntry).id)
+			c.lru.Remove(oldest)
+		}
+	}
+	return key
+}
+
+// findBase returns the cached entry that agrees with counters on the most
+// signers and has no signer that counters lacks.
+func (c *AggKeyCache) findBase(counters []uint32) *aggKeyEntry {
+	var (
+		best       *aggKeyEntry
+		bestShared int
+	)
+	for e := c.lru.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*aggKeyEntry)
+		shared := 0
+		for i, counter := range entry.counters {
+			if counter != 0 {
+				if counter != counters[i] {
+					shared = -1
+					break
+				}
+				shared++
+			}
+		}
+		if shared > bestShared {
+			best, bestShared = entry, shared
+		}
+	}
+	return best
+}
+
+func (c *AggKeyCache) derive(counters []uint32, base *aggKeyEntry) *pbc.Element {
+	numMissing := 0
+	onlyOnes := true
+	for _, counter := range counters {
+		if counter == 0 {
+			numMissing++
+		} else if counter != 1 {
+			onlyOnes = false
+		}
+	}
+	numNew := len(counters) - numMissing
+	if base != nil {
+		numNew = 0
+		for i, counter := range counters {
+			if counter != 0 && base.counters[i] == 0 {
+				numNew++
+			}
+		}
+	}
+
+	// Divide the missing validators out of the full key if that is cheaper
+	if onlyOnes && numMissing < numNew {
+		key := c.bls.pairing.NewG2().Set(c.fullKey)
+		for i, counter := range counters {
+			if counter == 0 {
+				key.ThenDiv(c.pubKeys[i])
+			}
+		}
+		return key
+	}
+
+	key := c.bls.pairing.NewG2()
+	var baseCounters []uint32
+	if base != nil {
+		key.Set(base.key)
+		baseCounters = base.counters
+	}
+	tempKey := c.bls.pairing.NewG2()
+	tempNum := c.bls.pairing.NewZr()
+	for i, counter := range counters {
+		if counter == 0 || (baseCounters != nil && baseCounters[i] != 0) {
+			continue
+		}
+		if counter == 1 {
+			key.ThenMul(c.pubKeys[i])
+		} else {
+			tempNum.SetInt32(int32(counter))
+			tempKey.PowZn(c.pubKeys[i], tempNum)
+			key.ThenMul(tempKey)
+		}
+	}
+	return key
+}
@@ -0,0 +1,112 @@
+package PairBFT
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/Nik-U/pbc"
+)
+
+func genTestPubKeys(bls *BLS, numVals int) []*pbc.Element {
+	pubKeys := make([]*pbc.Element, numVals)
+	for i := range pubKeys {
+		_, pubKeys[i] = bls.GenKey()
+	}
+	return pubKeys
+}
+
+// genQuorumCounters returns counters where each validator signed once, with
+// a few missing, like the aggregates seen during one phase.
+func genQuorumCounters(numVals int, numMissing int) []uint32 {
+	counters := make([]uint32, numVals)
+	for i := range counters {
+		counters[i] = 1
+	}
+	for _, i := range rand.Perm(numVals)[:numMissing] {
+		counters[i] = 0
+	}
+	return counters
+}
+
+func TestAggKeyCache(t *testing.T) {
+	numVals := 20
+	bls := &BLS{}
+	bls.Init()
+	pubKeys := genTestPubKeys(bls, numVals)
+
+	cache := &AggKeyCache{}
+	cache.Init(bls, pubKeys, 8)
+
+	for rep := 0; rep < 200; rep++ {
+		var counters []uint32
+		switch rep % 4 {
+		case 0:
+			counters = genQuorumCounters(numVals, rand.Intn(numVals/3))
+		case 1:
+			counters = genQuorumCounters(numVals, numVals-1-rand.Intn(numVals/3))
+		default:
+			// Overlapping aggregates may count a validator more than once
+			counters = make([]uint32, numVals)
+			for i := range counters {
+				counters[i] = uint32(rand.Intn(3))
+			}
+		}
+		want := computeAggKey(bls, pubKeys, counters)
+		if !cache.AggKey(counters).Equals(want) {
+			t.Fatal("Wrong aggregated key for counters", counters)
+		}
+		// Same again, now from the cache
+		if !cache.AggKey(counters).Equals(want) {
+			t.Fatal("Wrong cached key for counters", counters)
+		}
+	}
+	if cache.lru.Len() > 8 {
+		t.Error("Cache exceeds its size:", cache.lru.Len())
+	}
+}
+
+func benchmarkAggKey(b *testing.B, numVals int, keys func(bls *BLS, pubKeys []*pbc.Element) AggKeySource) {
+	bls := &BLS{}
+	bls.Init()
+	pubKeys := genTestPubKeys(bls, numVals)
+	source := keys(bls, pubKeys)
+
+	// The counters of one phase, where each aggregate adds a few signers
+	vectors := make([][]uint32, 16)
+	counters := genQuorumCounters(numVals, numVals-numVals/3)
+	for i := range vectors {
+		counters = append([]uint32(nil), counters...)
+		for _, j := range rand.Perm(numVals)[:2] {
+			counters[j] = 1
+		}
+		vectors[i] = counters
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		source.AggKey(vectors[i%len(vectors)])
+	}
+}
+
+func BenchmarkAggKey(b *testing.B) {
+	uncached := func(bls *BLS, pubKeys []*pbc.Element) AggKeySource {
+		return &pubKeyList{bls: bls, pubKeys: pubKeys}
+	}
+	cached := func(bls *BLS, pubKeys []*pbc.Element) AggKeySource {
+		cache := &AggKeyCache{}
+		cache.Init(bls, pubKeys, AggKeyCacheSize)
+		return cache
+	}
+	// A cache too small to hit, so every key is derived incrementally
+	incremental := func(bls *BLS, pubKeys []*pbc.Element) AggKeySource {
+		cache := &AggKeyCache{}
+		cache.Init(bls, pubKeys, 1)
+		return cache
+	}
+	for _, numVals := range []int{10, 100, 1000} {
+		b.Run(fmt.Sprintf("uncached_n%d", numVals), func(b *testing.B) { benchmarkAggKey(b, numVals, uncached) })
+		b.Run(fmt.Sprintf("cached_n%d", numVals), func(b *testing.B) { benchmarkAggKey(b, numVals, cached) })
+		b.Run(fmt.Sprintf("incremental_n%d", numVals), func(b *testing.B) { benchmarkAggKey(b, numVals, incremental) })
+	}
+}
@@ -0,0 +1,251 @@
+package PairBFT
+
+import (
+	"github.com/Nik-U/pbc"
+)
+
+// AggPool collects the aggregates and individual signatures received during
+// one phase and combines them. Merging two partially overlapping aggregates
+// adds up the counters of the common signers; the pool divides their
+// individual signatures back out where it knows them, and refuses merges
+// that would push a counter above maxCount. Individual signatures are learnt
+// from single-signer aggregates and from pairs of aggregates that differ by
+// exactly one signer.
+type (
+	AggPool struct {
+		bls        *BLS
+		size       int
+		maxCount   uint32
+		candidates []*AggSig
+		singles    []*pbc.Element // indexed by validator, nil if unknown
+		best       *AggSig
+	}
+)
+
+const (
+	AggPoolSize        = 8
+	MaxSigMultiplicity = 3
+)
+
+func (p *AggPool) Init(bls *BLS, numVals int, size int, maxCount uint32) {
+	p.bls = bls
+	p.size = size
+	p.maxCount = maxCount
+	p.singles = make([]*pbc.Element, numVals)
+}
+
+// Reset empties the pool for a new phase.
+func (p *AggPool) Reset() {
+	p.candidates = nil
+	for i := range p.singles {
+		p.singles[i] = nil
+	}
+	p.best = nil
+}
+
+func (p *AggPool) AddSingle(id int, sig *pbc.Element) {
+	if p.singles[id] == nil {
+		p.singles[id] = p.bls.cloneSig(sig)
+	}
+}
+
+// Best returns the combination of everything added so far. It is never
+// modified by the pool afterwards.
+func (p *AggPool) Best() *AggSig {
+	return p.best
+}
+
+// Add puts a verified aggregate into the pool and returns the new best
+// combination, which has at least as many signers as any aggregate added.
+func (p *AggPool) Add(sig *AggSig) *AggSig {
+	if id, ok := singleSigner(sig); ok {
+		p.AddSingle(id, sig.sig)
+	}
+	for _, c := range p.candidates {
+		p.learnSingle(sig, c)
+		p.learnSingle(c, sig)
+	}
+
+	p.insert(sig)
+	p.best = p.combine()
+	// The best combination is a candidate too, so it is not lost when the
+	// aggregates it was made of are evicted
+	p.insert(p.best)
+	return p.best
+}
+
+// insert adds a copy of sig to the candidates unless one of them has all its
+// signers with counters as low, and removes the candidates sig makes useless.
+func (p *AggPool) insert(sig *AggSig) {
+	for _, c := range p.candidates {
+		if isSubSet(sig, c) && maxCounter(c) <= maxCounter(sig) {
+			return
+		}
+	}
+	kept := p.candidates[:0]
+	for _, c := range p.candidates {
+		if !isSubSet(c, sig) || maxCounter(sig) > maxCounter(c) {
+			kept = append(kept, c)
+		}
+	}
+	p.candidates = append(kept, p.cloneAggSig(sig))
+	if len(p.candidates) > p.size {
+		p.dropSmallest()
+	}
+}
+
+func singleSigner(sig *AggSig) (int, bool) {
+	id := -1
+	for i, counter := range sig.counters {
+		if counter == 0 {
+			continue
+		}
+		if counter != 1 || id >= 0 {
+			return 0, false
+		}
+		id = i
+	}
+	return id, id >= 0
+}
+
+func maxCounter(sig *AggSig) uint32 {
+	var max uint32
+	for _, counter := range sig.counters {
+		if counter > max {
+			max = counter
+		}
+	}
+	return max
+}
+
+// learnSingle divides b by a if their counters differ only by one signer
+// whose signature is unknown.
+func (p *AggPool) learnSingle(a *AggSig, b *AggSig) {
+	id := -1
+	for i, counter := range b.counters {
+		switch {
+		case counter == a.counters[i]:
+		case counter == 1 && a.counters[i] == 0 && id < 0:
+			id = i
+		default:
+			return
+		}
+	}
+	if id >= 0 && p.singles[id] == nil {
+		p.singles[id] = p.bls.pairing.NewG1().Div(b.sig, a.sig)
+	}
+}
+
+func (p *AggPool) cloneAggSig(sig *AggSig) *AggSig {
+	c := &AggSig{counters: append([]uint32(nil), sig.counters...)}
+	c.sig = p.bls.cloneSig(sig.sig)
+	return c
+}
+
+func (p *AggPool) dropSmallest() {
+	smallest := 0
+	for i, c := range p.candidates {
+		if c.NumSigners() < p.candidates[smallest].NumSigners() {
+			smallest = i
+		}
+	}
+	p.candidates = append(p.candidates[:smallest], p.candidates[smallest+1:]...)
+}
+
+// combine greedily merges the candidate with the most signers with the ones
+// adding the most new signers, then adds the known individual signatures.
+func (p *AggPool) combine() *AggSig {
+	start := -1
+	for i, c := range p.candidates {
+		if start < 0 || c.NumSigners() > p.candidates[start].NumSigners() {
+			start = i
+		}
+	}
+	cur := &AggSig{}
+	if start < 0 {
+		cur.Init(p.bls, len(p.singles))
+	} else {
+		cur = p.cloneAggSig(p.candidates[start])
+		p.normalize(cur)
+	}
+
+	used := make([]bool, len(p.candidates))
+	if start >= 0 {
+		used[start] = true
+	}
+	for {
+		next, nextGain := -1, 0
+		for i, c := range p.candidates {
+			if used[i] {
+				continue
+			}
+			if gain := p.mergeGain(cur, c); gain > nextGain {
+				next, nextGain = i, gain
+			}
+		}
+		if next < 0 {
+			break
+		}
+		used[next] = true
+		p.merge(cur, p.candidates[next])
+	}
+
+	for i, single := range p.singles {
+		if single != nil && cur.counters[i] == 0 {
+			cur.sig.ThenMul(single)
+			cur.counters[i] = 1
+		}
+	}
+	return cur
+}
+
+// mergeGain returns the number of signers merging c into cur would add, or 0
+// if the merge would exceed the multiplicity bound.
+func (p *AggPool) mergeGain(cur *AggSig, c *AggSig) int {
+	gain := 0
+	for i, counter := range c.counters {
+		if counter == 0 {
+			continue
+		}
+		if cur.counters[i] == 0 {
+			gain++
+		}
+		if p.singles[i] == nil && cur.counters[i]+counter > p.maxCount {
+			return 0
+		}
+	}
+	return gain
+}
+
+func (p *AggPool) merge(cur *AggSig, c *AggSig) {
+	cur.sig.ThenMul(c.sig)
+	for i, counter := range c.counters {
+		cur.counters[i] += counter
+	}
+	p.normalize(cur)
+}
+
+// normalize divides out the known individual signatures counted more than
+// once.
+func (p *AggPool) normalize(sig *AggSig) {
+	for i, counter := range sig.counters {
+		if counter > 1 && p.singles[i] != nil {
+			p.divide(sig, i, counter-1)
+			sig.counters[i] = 1
+		}
+	}
+}
+
+func (p *AggPool) divide(sig *AggSig, id int, count uint32) {
+	if count == 1 {
+		sig.sig.ThenDiv(p.singles[id])
+		return
+	}
+	e := p.bls.pairing.NewZr().SetInt32(int32(count))
+	sig.sig.ThenDiv(p.bls.pairing.NewG1().PowZn(p.singles[id], e))
+}
+
+// aggregate merges aggSig into the aggregate of the current phase.
+func (val *Validator) aggregate(aggSig *AggSig) {
+	val.aggSig = val.aggPool.Add(aggSig)
+}
@@ -0,0 +1,66 @@
+package PairBFT
+
+import (
+	"testing"
+
+	"github.com/Nik-U/pbc"
+)
+
+func genTestAggSig(bls *BLS, hash []byte, privKeys []*pbc.Element, signers ...int) *AggSig {
+	sig := &AggSig{}
+	sig.Init(bls, len(privKeys))
+	for _, i := range signers {
+		sig.sig.ThenMul(bls.SignHash(hash, privKeys[i]))
+		sig.counters[i]++
+	}
+	return sig
+}
+
+func TestAggPool(t *testing.T) {
+	numVals := 10
+	bls := &BLS{}
+	bls.Init()
+	privKeys := make([]*pbc.Element, numVals)
+	pubKeys := make([]*pbc.Element, numVals)
+	for i := range privKeys {
+		privKeys[i], pubKeys[i] = bls.GenKey()
+	}
+	hash := getNoncedHash(getBlockHash([]byte("block"), make([]byte, LenHash)), NoncePrepare)
+
+	pool := &AggPool{}
+	pool.Init(bls, numVals, 4, 2)
+	pool.AddSingle(0, bls.SignHash(hash, privKeys[0]))
+
+	check := func(sig *AggSig, numSigners int) {
+		if !sig.Verify(bls, hash, pubKeys) {
+			t.Fatal("Invalid aggregate:", sig.counters)
+		}
+		if sig.NumSigners() != numSigners {
+			t.Error("Expected", numSigners, "signers, got", sig.counters)
+		}
+		if maxCounter(sig) > 2 {
+			t.Error("Counter above bound:", sig.counters)
+		}
+	}
+
+	// Overlapping on 0, whose signature is known, and on 3, which is not
+	check(pool.Add(genTestAggSig(bls, hash, privKeys, 0, 1, 2, 3)), 4)
+	check(pool.Add(genTestAggSig(bls, hash, privKeys, 0, 3, 4, 5)), 6)
+	best := pool.Best()
+	if best.counters[0] != 1 || best.counters[3] != 2 {
+		t.Error("Known signature not divided out:", best.counters)
+	}
+
+	// Would count 3 three times
+	check(pool.Add(genTestAggSig(bls, hash, privKeys, 3, 6)), 6)
+
+	// Teaches the signature of 6, and with the previous one that of 3, so
+	// that 3 can be divided out
+	check(pool.Add(genTestAggSig(bls, hash, privKeys, 6)), 7)
+	if pool.Best().counters[3] != 1 {
+		t.Error("Learnt signature not divided out:", pool.Best().counters)
+	}
+
+	// Adding a subset never makes the aggregate worse
+	check(pool.Add(genTestAggSig(bls, hash, privKeys, 1, 2)), 7)
+}
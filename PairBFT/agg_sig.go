@@ -46,25 +46,44 @@ func (sig *AggSig) SetBytes(b []byte) int {
 	return j
 }
 
-func (sig *AggSig) computeAggKey(bls *BLS, pubKeys []*pbc.Element) *pbc.Element {
-	numVals := len(sig.counters)
+type (
+	// AggKeySource computes the product of pubKeys[i]^counters[i].
+	AggKeySource interface {
+		AggKey(counters []uint32) *pbc.Element
+	}
+
+	pubKeyList struct {
+		bls     *BLS
+		pubKeys []*pbc.Element
+	}
+)
+
+func (l *pubKeyList) AggKey(counters []uint32) *pbc.Element {
+	return computeAggKey(l.bls, l.pubKeys, counters)
+}
+
+func computeAggKey(bls *BLS, pubKeys []*pbc.Element, counters []uint32) *pbc.Element {
 	vPubKey := bls.pairing.NewG2()
 	tempKey := bls.pairing.NewG2()
 	tempNum := bls.pairing.NewZr()
-	for i := 0; i < numVals; i++ {
-		if sig.counters[i] != 0 {
-			tempNum.SetInt32(int32(sig.counters[i]))
+	for i, counter := range counters {
+		switch counter {
+		case 0:
+		case 1:
+			vPubKey.ThenMul(pubKeys[i])
+		default:
+			tempNum.SetInt32(int32(counter))
 			tempKey.PowZn(pubKeys[i], tempNum)
-			if i == 0 {
-				vPubKey.Set(tempKey)
-			} else {
-				vPubKey.ThenMul(tempKey)
-			}
+			vPubKey.ThenMul(tempKey)
 		}
 	}
 	return vPubKey
 }
 
+func (sig *AggSig) computeAggKey(bls *BLS, pubKeys []*pbc.Element) *pbc.Element {
+	return computeAggKey(bls, pubKeys, sig.counters)
+}
+
 func (sig *AggSig) Verify(bls *BLS, hash []byte, pubKeys []*pbc.Element) bool {
 	vPubKey := sig.computeAggKey(bls, pubKeys)
 	return bls.VerifyHash(hash, sig.sig, vPubKey)
@@ -75,6 +94,11 @@ func (sig *AggSig) VerifyPreprocessed(bls *BLS, hash *pbc.Pairer, pubKeys []*pbc
 	return bls.VerifyPreprocessed(hash, sig.sig, vPubKey)
 }
 
+func (sig *AggSig) VerifyPreprocessedWith(bls *BLS, hash *pbc.Pairer, keys AggKeySource) bool {
+	vPubKey := keys.AggKey(sig.counters)
+	return bls.VerifyPreprocessed(hash, sig.sig, vPubKey)
+}
+
 func (sig *AggSig) Aggregate(otherSig *AggSig) {
 	numVals := len(sig.counters)
 	isSuperSet := true
@@ -113,13 +137,17 @@ func (sig *AggSig) AggregateOne(id uint32, otherSig *pbc.Element) {
 	sig.counters[id] = 1
 }
 
-func (sig *AggSig) ReachQuorum() bool {
-	numVals := len(sig.counters)
+func (sig *AggSig) NumSigners() int {
 	c := 0
-	for i := 0; i < numVals; i++ {
-		if sig.counters[i] > 0 {
+	for _, counter := range sig.counters {
+		if counter > 0 {
 			c++
 		}
 	}
-	return c > numVals/3*2
+	return c
+}
+
+func (sig *AggSig) ReachQuorum() bool {
+	numVals := len(sig.counters)
+	return sig.NumSigners() > numVals/3*2
 }
@@ -0,0 +1,181 @@
+package PairBFT
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Outgoing messages draw from a token bucket of bytes. Messages of lower
+// priority may not drain the bucket below a reserve, so when the link is
+// saturated the Commit and CommitPrepare messages of the current height still
+// go out while stale messages are dropped. Priorities only reserve headroom:
+// messages are sent or dropped in the order they are generated, never
+// reordered.
+const (
+	PriorityLow = iota
+	PriorityNormal
+	PriorityHigh
+)
+
+const (
+	numMsgTypes = MsgTypeAddrRecord + 1
+)
+
+var (
+	ErrInvalidBandwidthLimit = errors.New("bandwidth burst smaller than the maximum message size")
+)
+
+type (
+	TokenBucket struct {
+		mutex  sync.Mutex
+		rate   float64 // tokens per second
+		burst  float64
+		tokens float64
+		last   time.Time
+	}
+
+	SendStats struct {
+		BytesSent    map[string]uint64
+		BytesDropped map[string]uint64
+	}
+
+	sendCounters struct {
+		bytesSent    [numMsgTypes]uint64
+		bytesDropped [numMsgTypes]uint64
+	}
+)
+
+func MsgTypeName(msgType byte) string {
+	switch msgType {
+	case MsgTypePrepare:
+		return "Prepare"
+	case MsgTypeCommit:
+		return "Commit"
+	case MsgTypeCommitPrepare:
+		return "CommitPrepare"
+	case MsgTypeSyncRequest:
+		return "SyncRequest"
+	case MsgTypeSyncResponse:
+		return "SyncResponse"
+	case MsgTypeFragment:
+		return "Fragment"
+	case MsgTypeAddrRecord:
+		return "AddrRecord"
+	}
+	return "Unknown"
+}
+
+func (b *TokenBucket) Init(rate float64, burst float64) {
+	b.rate = rate
+	b.burst = burst
+	b.tokens = burst
+	b.last = time.Now()
+}
+
+// Take removes n tokens if at least reserve tokens would remain afterwards.
+func (b *TokenBucket) Take(n float64, reserve float64, now time.Time) bool {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if now.After(b.last) {
+		b.tokens += now.Sub(b.last).Seconds() * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+		b.last = now
+	}
+	if b.tokens-n < reserve {
+		return false
+	}
+	b.tokens -= n
+	return true
+}
+
+func (b *TokenBucket) reserve(priority int) float64 {
+	switch priority {
+	case PriorityHigh:
+		return 0
+	case PriorityNormal:
+		return b.burst / 4
+	}
+	return b.burst / 2
+}
+
+// SetBandwidthLimit caps outgoing traffic at rate bytes per second, with
+// bursts of up to burst bytes. A rate of 0 removes the limit. The burst must
+// hold a message of MaxMsgSize, or large messages could never be sent.
+func (val *Validator) SetBandwidthLimit(rate int, burst int) error {
+	if rate <= 0 {
+		val.bandwidth = nil
+		return nil
+	}
+	if burst < MaxMsgSize {
+		return ErrInvalidBandwidthLimit
+	}
+	b := &TokenBucket{}
+	b.Init(float64(rate), float64(burst))
+	val.bandwidth = b
+	return nil
+}
+
+func (val *Validator) msgPriority(data []byte) int {
+	msgType := data[0]
+	if msgType != MsgTypePrepare && msgType != MsgTypeCommit && msgType != MsgTypeCommitPrepare {
+		return PriorityLow
+	}
+	if len(data) < LenMsgType+LenBlockHeight {
+		return PriorityLow
+	}
+	blockHeight := binary.LittleEndian.Uint64(data[LenMsgType:])
+
+	val.stateMutex.Lock()
+	currentHeight := val.blockHeight
+	val.stateMutex.Unlock()
+
+	if blockHeight < currentHeight {
+		return PriorityLow
+	}
+	if msgType == MsgTypePrepare {
+		return PriorityNormal
+	}
+	return PriorityHigh
+}
+
+// allowSend charges data against the bandwidth budget and records it.
+func (val *Validator) allowSend(data []byte) bool {
+	msgType := data[0]
+	if msgType >= numMsgTypes {
+		msgType = MsgTypeUnknown
+	}
+	n := uint64(len(data))
+	if val.bandwidth != nil {
+		b := val.bandwidth
+		if !b.Take(float64(n), b.reserve(val.msgPriority(data)), time.Now()) {
+			atomic.AddUint64(&val.sendCounters.bytesDropped[msgType], n)
+			return false
+		}
+	}
+	atomic.AddUint64(&val.sendCounters.bytesSent[msgType], n)
+	return true
+}
+
+// SendStats returns the bytes sent and dropped so far, by message type.
+func (val *Validator) SendStats() SendStats {
+	stats := SendStats{
+		BytesSent:    make(map[string]uint64),
+		BytesDropped: make(map[string]uint64),
+	}
+	for t := byte(0); t < numMsgTypes; t++ {
+		name := MsgTypeName(t)
+		if sent := atomic.LoadUint64(&val.sendCounters.bytesSent[t]); sent != 0 {
+			stats.BytesSent[name] = sent
+		}
+		if dropped := atomic.LoadUint64(&val.sendCounters.bytesDropped[t]); dropped != 0 {
+			stats.BytesDropped[name] = dropped
+		}
+	}
+	return stats
+}
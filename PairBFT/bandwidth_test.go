@@ -0,0 +1,74 @@
+package PairBFT
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketReserve(t *testing.T) {
+	b := &TokenBucket{}
+	b.Init(100, 100)
+	now := b.last
+
+	if !b.Take(40, b.reserve(PriorityLow), now) {
+		t.Error("Low priority rejected with a full bucket.")
+	}
+	if b.Take(20, b.reserve(PriorityLow), now) {
+		t.Error("Low priority drained the reserve.")
+	}
+	if !b.Take(20, b.reserve(PriorityNormal), now) {
+		t.Error("Normal priority rejected above its reserve.")
+	}
+	if !b.Take(40, b.reserve(PriorityHigh), now) {
+		t.Error("High priority rejected with tokens left.")
+	}
+	if b.Take(1, b.reserve(PriorityHigh), now) {
+		t.Error("Took more tokens than the bucket holds.")
+	}
+	if !b.Take(50, b.reserve(PriorityHigh), now.Add(500*time.Millisecond)) {
+		t.Error("Bucket did not refill.")
+	}
+}
+
+func genTestMsgData(msgType byte, blockHeight uint64, size int) []byte {
+	data := make([]byte, size)
+	data[0] = msgType
+	binary.LittleEndian.PutUint64(data[LenMsgType:], blockHeight)
+	return data
+}
+
+func TestSendPriorities(t *testing.T) {
+	val := &Validator{blockHeight: 5}
+	if val.SetBandwidthLimit(1, MaxMsgSize-1) == nil {
+		t.Error("Burst below the maximum message size accepted.")
+	}
+	if err := val.SetBandwidthLimit(1, MaxMsgSize); err != nil {
+		t.Fatal(err)
+	}
+
+	size := MaxMsgSize / 10
+	stale := genTestMsgData(MsgTypeCommit, 4, size)
+	prepare := genTestMsgData(MsgTypePrepare, 5, size)
+	commit := genTestMsgData(MsgTypeCommit, 5, size)
+
+	sent := map[string]int{}
+	for i := 0; i < 10; i++ {
+		for _, data := range [][]byte{stale, prepare, commit} {
+			if val.allowSend(data) {
+				sent[MsgTypeName(data[0])+string('0'+data[1])]++
+			}
+		}
+	}
+	if sent["Commit5"] <= sent["Prepare5"] || sent["Prepare5"] <= sent["Commit4"] {
+		t.Error("Priorities not respected: ", sent)
+	}
+
+	stats := val.SendStats()
+	if stats.BytesSent["Commit"] != uint64(size*(sent["Commit5"]+sent["Commit4"])) {
+		t.Error("Wrong bytes sent: ", stats.BytesSent)
+	}
+	if stats.BytesDropped["Prepare"] != uint64(size*(10-sent["Prepare5"])) {
+		t.Error("Wrong bytes dropped: ", stats.BytesDropped)
+	}
+}
@@ -0,0 +1,88 @@
+package PairBFT
+
+import (
+	"errors"
+	"sync"
+)
+
+// A validator hands every block it finalizes to its BlockStore, together
+// with the aggregate that finalized it: the Commit aggregate of the block, or
+// in CommitPrepare mode the CommitPrepare aggregate of the block after it;
+// see FinalityCertificate.
+type (
+	FinalizedBlock struct {
+		Height uint64
+		Hash   []byte
+		// Nil for a block finalized through a sync in CommitPrepare mode,
+		// whose certificate covers only the data of its child
+		Data   []byte
+		AggSig *AggSig
+		// In CommitPrepare mode, the data of the block after it, whose
+		// CommitPrepare aggregate AggSig is
+		ChildData []byte
+		// The phase of AggSig, MsgTypeCommit or MsgTypeCommitPrepare, and the
+		// hash of the validator set that signed it
+		Phase      byte
+		ValSetHash []byte
+	}
+
+	BlockStore interface {
+		// Put stores a finalized block. Blocks come in increasing height
+		// order, possibly with gaps after a sync.
+		Put(block *FinalizedBlock) error
+		// Get returns the block at height, or ErrBlockNotFound.
+		Get(height uint64) (*FinalizedBlock, error)
+		// Height returns the height of the last stored block, 0 if none.
+		Height() uint64
+	}
+
+	MemBlockStore struct {
+		mutex  sync.RWMutex
+		blocks map[uint64]*FinalizedBlock
+		height uint64
+	}
+)
+
+var (
+	ErrBlockNotFound = errors.New("block not found")
+)
+
+func (s *MemBlockStore) Init() {
+	s.blocks = make(map[uint64]*FinalizedBlock)
+	s.height = 0
+}
+
+func (s *MemBlockStore) Put(block *FinalizedBlock) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.blocks[block.Height] = block
+	if block.Height > s.height {
+		s.height = block.Height
+	}
+	return nil
+}
+
+func (s *MemBlockStore) Get(height uint64) (*FinalizedBlock, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	block, ok := s.blocks[height]
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	return block, nil
+}
+
+func (s *MemBlockStore) Height() uint64 {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.height
+}
+
+func (val *Validator) storeBlock(block *FinalizedBlock) {
+	if val.store != nil {
+		if err := val.store.Put(block); err != nil {
+			val.log.Error("Failed to store block ", block.Height, ": ", err)
+		}
+	}
+	val.blocks.publish(block)
+}
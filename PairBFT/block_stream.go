@@ -0,0 +1,63 @@
+package PairBFT
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// The WebSocket endpoint streams the finalized blocks of a subscription as
+// JSON BlockInfo messages. The optional from query parameter is the height
+// to start from, see Subscribe. A client that reads too slowly is
+// disconnected with the reason in the close message.
+const (
+	StreamBufferSize = 16
+	streamWriteWait  = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{}
+
+func (val *Validator) BlockStreamHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var fromHeight uint64
+		if from := r.URL.Query().Get("from"); from != "" {
+			var err error
+			if fromHeight, err = strconv.ParseUint(from, 10, 64); err != nil {
+				http.Error(w, "invalid from height", http.StatusBadRequest)
+				return
+			}
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			val.log.Debug("WebSocket upgrade failed: ", err)
+			return
+		}
+		defer conn.Close()
+
+		sub := val.Subscribe(fromHeight, StreamBufferSize)
+		defer sub.Close()
+		// Reading is needed to notice that the client went away
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					sub.Close()
+					return
+				}
+			}
+		}()
+
+		for block := range sub.C {
+			conn.SetWriteDeadline(time.Now().Add(streamWriteWait))
+			if err := conn.WriteJSON(val.blockInfo(block)); err != nil {
+				val.log.Debug("WebSocket write failed: ", err)
+				return
+			}
+		}
+		if err := sub.Err(); err != nil {
+			msg := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, err.Error())
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteWait))
+		}
+	})
+}
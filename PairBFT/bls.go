@@ -2,7 +2,10 @@ package PairBFT
 
 import (
 	"github.com/Nik-U/pbc"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
+	"math/big"
 )
 
 type (
@@ -11,6 +14,14 @@ type (
 		g       *pbc.Element
 		params  *pbc.Params
 	}
+
+	// BatchItem is a signature to be checked by BatchVerify, as in
+	// VerifyPreprocessed.
+	BatchItem struct {
+		Hash   *pbc.Pairer
+		Sig    *pbc.Element
+		PubKey *pbc.Element
+	}
 )
 
 func (bls *BLS) Init() {
@@ -65,6 +76,65 @@ func (bls *BLS) VerifyPreprocessed(hash *pbc.Pairer, sig *pbc.Element, pubKey *p
 	return temp1.Equals(temp2)
 }
 
+// BatchVerify checks all items at once and tells which of them are valid. It
+// raises each item to a random 64-bit exponent r_i and checks
+// e(prod sig_i^r_i, g) = prod e(H, prod pubKey_i^r_i), with one pairing per
+// distinct hash instead of two per item. If the combined check fails, the
+// items are verified one by one to find the invalid ones.
+func (bls *BLS) BatchVerify(items []BatchItem) []bool {
+	valid := make([]bool, len(items))
+	if len(items) == 1 {
+		valid[0] = bls.VerifyPreprocessed(items[0].Hash, items[0].Sig, items[0].PubKey)
+		return valid
+	}
+
+	if bls.verifyCombined(items) {
+		for i := range valid {
+			valid[i] = true
+		}
+		return valid
+	}
+	for i, item := range items {
+		valid[i] = bls.VerifyPreprocessed(item.Hash, item.Sig, item.PubKey)
+	}
+	return valid
+}
+
+func (bls *BLS) verifyCombined(items []BatchItem) bool {
+	if len(items) == 0 {
+		return true
+	}
+
+	var (
+		hashes []*pbc.Pairer
+		keys   = make(map[*pbc.Pairer]*pbc.Element)
+	)
+	aggSig := bls.pairing.NewG1()
+	r := bls.pairing.NewZr()
+	b := make([]byte, 8)
+	for _, item := range items {
+		if _, err := rand.Read(b); err != nil {
+			return false
+		}
+		r.SetBig(new(big.Int).SetUint64(binary.LittleEndian.Uint64(b)))
+		aggSig.ThenMul(bls.pairing.NewG1().PowZn(item.Sig, r))
+
+		key, ok := keys[item.Hash]
+		if !ok {
+			key = bls.pairing.NewG2()
+			keys[item.Hash] = key
+			hashes = append(hashes, item.Hash)
+		}
+		key.ThenMul(bls.pairing.NewG2().PowZn(item.PubKey, r))
+	}
+
+	pairedHashes := bls.pairing.NewGT().PairerPair(hashes[0], keys[hashes[0]])
+	for _, hash := range hashes[1:] {
+		pairedHashes.ThenMul(bls.pairing.NewGT().PairerPair(hash, keys[hash]))
+	}
+	return pairedHashes.Equals(bls.PairSig(aggSig))
+}
+
 func (bls *BLS) PreprocessHash(hash []byte) *pbc.Pairer {
 	h := bls.pairing.NewG1().SetFromHash(hash)
 	return h.PreparePairer()
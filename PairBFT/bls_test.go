@@ -79,3 +79,34 @@ func TestTemp1(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestBLSBatchVerify(t *testing.T) {
+	bls := &BLS{}
+	bls.Init()
+
+	// Several signers per hash, as with the messages of one height
+	block := getBlockHash([]byte("block"), make([]byte, LenHash))
+	hashes := [][]byte{getNoncedHash(block, NoncePrepare), getNoncedHash(block, NonceCommit)}
+	var items []BatchItem
+	for _, hash := range hashes {
+		pairer := bls.PreprocessHash(hash)
+		for i := 0; i < 4; i++ {
+			privKey, pubKey := bls.GenKey()
+			items = append(items, BatchItem{pairer, bls.SignHash(hash, privKey), pubKey})
+		}
+	}
+
+	for i, ok := range bls.BatchVerify(items) {
+		if !ok {
+			t.Error("Valid item rejected:", i)
+		}
+	}
+
+	// A signature on the wrong hash
+	items[5].Sig = items[1].Sig
+	for i, ok := range bls.BatchVerify(items) {
+		if ok == (i == 5) {
+			t.Error("Wrong result for item", i, ":", ok)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package PairBFT
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+
+	"github.com/Nik-U/pbc"
+)
+
+// A FinalityCertificate proves that a block was finalized, to anyone who
+// knows the public keys of the validator set: AggSig is a quorum aggregate
+// over the Commit of Hash or, in CommitPrepare mode, over the CommitPrepare
+// of the child block, whose data is included so that its hash can be derived
+// from Hash. Validators sign block hashes only, so Height and ChainID are
+// not covered by AggSig; Verify checks them against the values the caller
+// expects.
+type (
+	FinalityCertificate struct {
+		ChainID    string
+		Height     uint64
+		Hash       []byte
+		Phase      byte // MsgTypeCommit or MsgTypeCommitPrepare
+		ChildData  []byte
+		AggSig     *AggSig
+		ValSetHash []byte
+	}
+
+	finalityCertificateJSON struct {
+		ChainID    string   `json:"chainId"`
+		Height     uint64   `json:"height"`
+		Hash       string   `json:"hash"`
+		Phase      string   `json:"phase"`
+		ChildData  string   `json:"childData,omitempty"`
+		Counters   []uint32 `json:"counters"`
+		Sig        string   `json:"sig"`
+		ValSetHash string   `json:"valSetHash"`
+	}
+)
+
+const (
+	certVersion      = 1
+	lenCertChainID   = 2
+	lenCertChildData = 4
+	lenCertNumVals   = 4
+	lenCertSig       = 2
+	MaxCertChainID   = 256
+)
+
+var (
+	ErrInvalidCertificate = errors.New("malformed finality certificate")
+	ErrCertValSet         = errors.New("certificate is for another validator set")
+	ErrCertNoQuorum       = errors.New("certificate aggregate has no quorum")
+	ErrCertSig            = errors.New("invalid certificate signature")
+	ErrCertLabel          = errors.New("certificate is for another chain or height")
+)
+
+// ValSetHash identifies a validator set by its public keys, in order.
+func ValSetHash(pubKeys []*pbc.Element) []byte {
+	h := sha256.New()
+	for _, pubKey := range pubKeys {
+		b := pubKey.Bytes()
+		var l [4]byte
+		binary.LittleEndian.PutUint32(l[:], uint32(len(b)))
+		h.Write(l[:])
+		h.Write(b)
+	}
+	return h.Sum(nil)
+}
+
+// signedHash returns the hash the aggregate signs.
+func (c *FinalityCertificate) signedHash() []byte {
+	if c.Phase == MsgTypeCommitPrepare {
+		return getNoncedHash(getBlockHash(c.ChildData, c.Hash), NonceCommitPrepare)
+	}
+	return getNoncedHash(c.Hash, NonceCommit)
+}
+
+// Verify checks c against the validator set pubKeys, for the block at height
+// on the chain chainID. A nil error proves that a quorum of pubKeys finalized
+// Hash, and in CommitPrepare mode that ChildData follows it. It does not prove
+// that Hash is at height on chainID, as neither is signed: the caller must
+// take them from a trusted source, such as the chain of hashes from a block
+// it already trusts, or from a validator set used by that chain only.
+func (c *FinalityCertificate) Verify(bls *BLS, pubKeys []*pbc.Element, chainID string, height uint64) error {
+	if c.ChainID != chainID || c.Height != height {
+		return ErrCertLabel
+	}
+	if c.Phase != MsgTypeCommit && c.Phase != MsgTypeCommitPrepare {
+		return ErrInvalidCertificate
+	}
+	if len(c.Hash) != LenHash || c.AggSig == nil {
+		return ErrInvalidCertificate
+	}
+	if len(c.AggSig.counters) != len(pubKeys) || !bytes.Equal(c.ValSetHash, ValSetHash(pubKeys)) {
+		return ErrCertValSet
+	}
+	if !c.AggSig.ReachQuorum() {
+		return ErrCertNoQuorum
+	}
+	if !c.AggSig.Verify(bls, c.signedHash(), pubKeys) {
+		return ErrCertSig
+	}
+	return nil
+}
+
+func (c *FinalityCertificate) Bytes() []byte {
+	sig := c.AggSig.sig.Bytes()
+	numVals := len(c.AggSig.counters)
+	l := 1 + lenCertChainID + len(c.ChainID) + LenBlockHeight + LenHash + LenMsgType + LenHash +
+		lenCertChildData + len(c.ChildData) + lenCertNumVals + lenCounter*numVals + lenCertSig + len(sig)
+	b := make([]byte, l)
+	i := 0
+	b[i] = certVersion
+	i++
+	binary.LittleEndian.PutUint16(b[i:], uint16(len(c.ChainID)))
+	i += lenCertChainID
+	i += copy(b[i:], c.ChainID)
+	binary.LittleEndian.PutUint64(b[i:], c.Height)
+	i += LenBlockHeight
+	copy(b[i:i+LenHash], c.Hash)
+	i += LenHash
+	b[i] = c.Phase
+	i += LenMsgType
+	copy(b[i:i+LenHash], c.ValSetHash)
+	i += LenHash
+	binary.LittleEndian.PutUint32(b[i:], uint32(len(c.ChildData)))
+	i += lenCertChildData
+	i += copy(b[i:], c.ChildData)
+	binary.LittleEndian.PutUint32(b[i:], uint32(numVals))
+	i += lenCertNumVals
+	for _, counter := range c.AggSig.counters {
+		binary.LittleEndian.PutUint32(b[i:], counter)
+		i += lenCounter
+	}
+	binary.LittleEndian.PutUint16(b[i:], uint16(len(sig)))
+	i += lenCertSig
+	copy(b[i:], sig)
+	return b
+}
+
+// SetBytes decodes b, as encoded by Bytes. It only checks the encoding; see
+// Verify.
+func (c *FinalityCertificate) SetBytes(bls *BLS, b []byte) error {
+	i := 0
+	if len(b) < 1+lenCertChainID || b[0] != certVersion {
+		return ErrInvalidCertificate
+	}
+	i++
+	l := int(binary.LittleEndian.Uint16(b[i:]))
+	i += lenCertChainID
+	if l > MaxCertChainID || len(b) < i+l+LenBlockHeight+LenHash+LenMsgType+LenHash+lenCertChildData {
+		return ErrInvalidCertificate
+	}
+	c.ChainID = string(b[i : i+l])
+	i += l
+	c.Height = binary.LittleEndian.Uint64(b[i:])
+	i += LenBlockHeight
+	c.Hash = append([]byte(nil), b[i:i+LenHash]...)
+	i += LenHash
+	c.Phase = b[i]
+	i += LenMsgType
+	c.ValSetHash = append([]byte(nil), b[i:i+LenHash]...)
+	i += LenHash
+	l = int(binary.LittleEndian.Uint32(b[i:]))
+	i += lenCertChildData
+	if l > MaxMsgSize || len(b) < i+l+lenCertNumVals {
+		return ErrInvalidCertificate
+	}
+	c.ChildData = nil
+	if l > 0 {
+		c.ChildData = append([]byte(nil), b[i:i+l]...)
+	}
+	i += l
+	numVals := int(binary.LittleEndian.Uint32(b[i:]))
+	i += lenCertNumVals
+	if numVals == 0 || numVals > (len(b)-i)/lenCounter {
+		return ErrInvalidCertificate
+	}
+	c.AggSig = &AggSig{}
+	c.AggSig.Init(bls, numVals)
+	for j := 0; j < numVals; j++ {
+		c.AggSig.counters[j] = binary.LittleEndian.Uint32(b[i:])
+		i += lenCounter
+	}
+	if len(b) < i+lenCertSig {
+		return ErrInvalidCertificate
+	}
+	l = int(binary.LittleEndian.Uint16(b[i:]))
+	i += lenCertSig
+	if l != c.AggSig.sig.BytesLen() || len(b) != i+l {
+		return ErrInvalidCertificate
+	}
+	c.AggSig.sig.SetBytes(b[i:])
+	return nil
+}
+
+func (c *FinalityCertificate) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&finalityCertificateJSON{
+		ChainID:    c.ChainID,
+		Height:     c.Height,
+		Hash:       hex.EncodeToString(c.Hash),
+		Phase:      MsgTypeName(c.Phase),
+		ChildData:  hex.EncodeToString(c.ChildData),
+		Counters:   c.AggSig.counters,
+		Sig:        hex.EncodeToString(c.AggSig.sig.Bytes()),
+		ValSetHash: hex.EncodeToString(c.ValSetHash),
+	})
+}
+
+// SetJSON decodes data, as encoded by MarshalJSON.
+func (c *FinalityCertificate) SetJSON(bls *BLS, data []byte) error {
+	var j finalityCertificateJSON
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+	if len(j.ChainID) > MaxCertChainID {
+		return ErrInvalidCertificate
+	}
+	var err error
+	c.ChainID = j.ChainID
+	c.Height = j.Height
+	switch j.Phase {
+	case MsgTypeName(MsgTypeCommit):
+		c.Phase = MsgTypeCommit
+	case MsgTypeName(MsgTypeCommitPrepare):
+		c.Phase = MsgTypeCommitPrepare
+	default:
+		return ErrInvalidCertificate
+	}
+	if c.Hash, err = hex.DecodeString(j.Hash); err != nil {
+		return err
+	}
+	if c.ChildData, err = hex.DecodeString(j.ChildData); err != nil {
+		return err
+	}
+	if len(c.ChildData) == 0 {
+		c.ChildData = nil
+	}
+	if c.ValSetHash, err = hex.DecodeString(j.ValSetHash); err != nil {
+		return err
+	}
+	sig, err := hex.DecodeString(j.Sig)
+	if err != nil {
+		return err
+	}
+	if len(j.Counters) == 0 {
+		return ErrInvalidCertificate
+	}
+	c.AggSig = &AggSig{}
+	c.AggSig.Init(bls, len(j.Counters))
+	copy(c.AggSig.counters, j.Counters)
+	if len(sig) != c.AggSig.sig.BytesLen() {
+		return ErrInvalidCertificate
+	}
+	c.AggSig.sig.SetBytes(sig)
+	return nil
+}
+
+// Certificate returns the finality certificate of the stored block at height.
+func (val *Validator) Certificate(height uint64) (*FinalityCertificate, error) {
+	if val.store == nil {
+		return nil, ErrBlockNotFound
+	}
+	block, err := val.store.Get(height)
+	if err != nil {
+		return nil, err
+	}
+	return val.certificate(block), nil
+}
+
+func (val *Validator) certificate(block *FinalizedBlock) *FinalityCertificate {
+	return &FinalityCertificate{
+		ChainID:    val.chainID,
+		Height:     block.Height,
+		Hash:       block.Hash,
+		Phase:      block.Phase,
+		ChildData:  block.ChildData,
+		AggSig:     block.AggSig,
+		ValSetHash: block.ValSetHash,
+	}
+}
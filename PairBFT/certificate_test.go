@@ -0,0 +1,107 @@
+package PairBFT
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Nik-U/pbc"
+)
+
+func TestFinalityCertificate(t *testing.T) {
+	numVals := 4
+	bls := &BLS{}
+	bls.Init()
+	privKeys := make([]*pbc.Element, numVals)
+	pubKeys := make([]*pbc.Element, numVals)
+	for i := range privKeys {
+		privKeys[i], pubKeys[i] = bls.GenKey()
+	}
+	hash := getBlockHash([]byte("block"), make([]byte, LenHash))
+	childData := []byte("child")
+
+	commit := &FinalityCertificate{
+		ChainID:    "test",
+		Height:     7,
+		Hash:       hash,
+		Phase:      MsgTypeCommit,
+		AggSig:     genTestAggSig(bls, getNoncedHash(hash, NonceCommit), privKeys, 0, 1, 3),
+		ValSetHash: ValSetHash(pubKeys),
+	}
+	commitPrepare := &FinalityCertificate{
+		ChainID:    "test",
+		Height:     7,
+		Hash:       hash,
+		Phase:      MsgTypeCommitPrepare,
+		ChildData:  childData,
+		AggSig:     genTestAggSig(bls, getNoncedHash(getBlockHash(childData, hash), NonceCommitPrepare), privKeys, 0, 1, 2),
+		ValSetHash: ValSetHash(pubKeys),
+	}
+
+	for _, c := range []*FinalityCertificate{commit, commitPrepare} {
+		if err := c.Verify(bls, pubKeys, "test", 7); err != nil {
+			t.Error("Valid certificate rejected:", err)
+		}
+		if err := c.Verify(bls, pubKeys, "other", 7); err != ErrCertLabel {
+			t.Error("Expected", ErrCertLabel, "got", err)
+		}
+		if err := c.Verify(bls, pubKeys, "test", 8); err != ErrCertLabel {
+			t.Error("Expected", ErrCertLabel, "got", err)
+		}
+
+		decoded := &FinalityCertificate{}
+		if err := decoded.SetBytes(bls, c.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Bytes(), c.Bytes()) || decoded.Verify(bls, pubKeys, "test", 7) != nil {
+			t.Error("Binary encoding does not round-trip")
+		}
+		if decoded.SetBytes(bls, c.Bytes()[:len(c.Bytes())-1]) != ErrInvalidCertificate {
+			t.Error("Truncated certificate accepted")
+		}
+
+		data, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decoded = &FinalityCertificate{}
+		if err := decoded.SetJSON(bls, data); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(decoded.Bytes(), c.Bytes()) {
+			t.Error("JSON encoding does not round-trip")
+		}
+		long := *c
+		long.ChainID = strings.Repeat("x", MaxCertChainID+1)
+		if data, err = json.Marshal(&long); err != nil {
+			t.Fatal(err)
+		}
+		if decoded.SetJSON(bls, data) != ErrInvalidCertificate {
+			t.Error("Overlong chain ID accepted")
+		}
+	}
+
+	tampered := *commit
+	tampered.Hash = getBlockHash([]byte("other"), make([]byte, LenHash))
+	if err := tampered.Verify(bls, pubKeys, "test", 7); err != ErrCertSig {
+		t.Error("Expected", ErrCertSig, "got", err)
+	}
+	tampered = *commit
+	tampered.AggSig = genTestAggSig(bls, getNoncedHash(hash, NonceCommit), privKeys, 0, 1)
+	if err := tampered.Verify(bls, pubKeys, "test", 7); err != ErrCertNoQuorum {
+		t.Error("Expected", ErrCertNoQuorum, "got", err)
+	}
+	_, otherKey := bls.GenKey()
+	otherKeys := []*pbc.Element{pubKeys[0], pubKeys[1], pubKeys[2], otherKey}
+	if err := commit.Verify(bls, otherKeys, "test", 7); err != ErrCertValSet {
+		t.Error("Expected", ErrCertValSet, "got", err)
+	}
+
+	// A stored block keeps the validator set and phase it was finalized with
+	val := &Validator{chainID: "test", valSetHash: ValSetHash(otherKeys), useCommitPrepare: true}
+	block := &FinalizedBlock{Height: 7, Hash: hash, AggSig: commit.AggSig, Phase: MsgTypeCommit, ValSetHash: commit.ValSetHash}
+	if err := val.certificate(block).Verify(bls, pubKeys, "test", 7); err != nil {
+		t.Error("Certificate of a stored block:", err)
+	}
+}
@@ -0,0 +1,209 @@
+package PairBFT
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Nik-U/pbc"
+	"github.com/sirupsen/logrus"
+)
+
+// Config holds everything NewValidator needs. The validator set is given by
+// index: validator i has address ValAddrs[i] and public key ValPubKeys[i],
+// whose proof of possession is ValPubKeySigs[i], see GenValidatorKeys.
+type (
+	Config struct {
+		// Identity and keys. An observer has no PrivKey, and its ID is
+		// len(ValAddrs) plus its index in ObserverAddrs.
+		ChainID  string // named in finality certificates
+		ID       int
+		Observer bool
+		BLS      *BLS
+		PrivKey  *pbc.Element
+		NodeKey  ed25519.PrivateKey // generated if nil
+
+		// Validator set
+		ValAddrs       []string
+		ValPubKeys     []*pbc.Element
+		ValPubKeySigs  []*pbc.Element
+		ValNodeKeys    []ed25519.PublicKey // optional, for the secure transport
+		ValNodeKeySigs []*pbc.Element
+		// Non-voting observers, see IsObserver; not reachable over the
+		// secure transport
+		ObserverAddrs []string
+
+		// Timing: a fixed send interval and fan-out, or an adaptive schedule
+		EpochLen     time.Duration
+		BranchFactor int
+		Schedule     *GossipSchedule
+
+		// Mode
+		UseCommitPrepare bool
+		GossipMode       int
+
+		// Transport to the peers; a UDPTransport on ValAddrs[ID] if nil
+		Transport Transport
+		// Store of finalized blocks, none if nil
+		Store BlockStore
+		// Logger; a logger writing to stderr at InfoLevel if nil
+		Logger *logrus.Logger
+		// Where to write the state transitions as JSON lines, see Event
+		EventLog io.Writer
+		// Address to serve Prometheus metrics on at /metrics, none if empty
+		MetricsAddr string
+		// Loopback address to serve the JSON-RPC API and the block stream on,
+		// none if empty
+		RPCAddr string
+		// Address to serve the JSON-RPC API without the admin calls and the
+		// block stream on, for light clients; none if empty
+		PublicRPCAddr string
+	}
+)
+
+// GenValidatorKeys generates a BLS key pair and the proof of possession of
+// the private key that other validators expect in Config.ValPubKeySigs.
+func GenValidatorKeys(bls *BLS) (privKey *pbc.Element, pubKey *pbc.Element, pubKeySig *pbc.Element) {
+	privKey, pubKey = bls.GenKey()
+	pubKeySig = bls.SignHash(getNoncedHash(pubKey.Bytes(), NoncePubKey), privKey)
+	return
+}
+
+// FileLogger returns a logger writing to fileName, which is truncated, at
+// InfoLevel.
+func FileLogger(fileName string) (*logrus.Logger, error) {
+	fileName, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0666)
+	if err != nil {
+		return nil, err
+	}
+	log := logrus.New()
+	log.SetOutput(file)
+	return log, nil
+}
+
+// Validate checks that cfg is complete and consistent, including the proofs
+// of possession of the validator set.
+func (cfg *Config) Validate() error {
+	if cfg.BLS == nil {
+		return errors.New("config: BLS is required")
+	}
+	numVals := len(cfg.ValPubKeys)
+	if numVals == 0 {
+		return errors.New("config: empty validator set")
+	}
+	if len(cfg.ValAddrs) != numVals || len(cfg.ValPubKeySigs) != numVals {
+		return errors.New("config: ValAddrs, ValPubKeys and ValPubKeySigs differ in length")
+	}
+	if cfg.Observer {
+		if cfg.PrivKey != nil {
+			return errors.New("config: an observer has no PrivKey")
+		}
+		if cfg.ID < numVals || cfg.ID >= numVals+len(cfg.ObserverAddrs) {
+			return fmt.Errorf("config: observer ID %d out of range [%d, %d)", cfg.ID, numVals, numVals+len(cfg.ObserverAddrs))
+		}
+	} else {
+		if cfg.PrivKey == nil {
+			return errors.New("config: PrivKey is required")
+		}
+		if cfg.ID < 0 || cfg.ID >= numVals {
+			return fmt.Errorf("config: ID %d out of range [0, %d)", cfg.ID, numVals)
+		}
+		pubKey := cfg.BLS.pairing.NewG2().PowZn(cfg.BLS.g, cfg.PrivKey)
+		if !pubKey.Equals(cfg.ValPubKeys[cfg.ID]) {
+			return fmt.Errorf("config: ValPubKeys[%d] does not match PrivKey", cfg.ID)
+		}
+	}
+	for i := 0; i < numVals; i++ {
+		h := getNoncedHash(cfg.ValPubKeys[i].Bytes(), NoncePubKey)
+		if !cfg.BLS.VerifyHash(h, cfg.ValPubKeySigs[i], cfg.ValPubKeys[i]) {
+			return fmt.Errorf("config: invalid proof of possession of validator %d", i)
+		}
+	}
+	if cfg.ValNodeKeys != nil {
+		if len(cfg.ObserverAddrs) > 0 {
+			return errors.New("config: observers cannot use the secure transport")
+		}
+		if len(cfg.ValNodeKeys) != numVals || len(cfg.ValNodeKeySigs) != numVals {
+			return errors.New("config: ValNodeKeys and ValNodeKeySigs differ in length from the validator set")
+		}
+		if cfg.NodeKey == nil || !bytes.Equal(cfg.NodeKey.Public().(ed25519.PublicKey), cfg.ValNodeKeys[cfg.ID]) {
+			return fmt.Errorf("config: ValNodeKeys[%d] does not match NodeKey", cfg.ID)
+		}
+	}
+
+	if cfg.Schedule != nil {
+		if err := cfg.Schedule.validate(); err != nil {
+			return errors.New("config: " + err.Error())
+		}
+	} else {
+		if cfg.EpochLen <= 0 {
+			return errors.New("config: EpochLen must be positive")
+		}
+		if cfg.BranchFactor < 1 {
+			return errors.New("config: BranchFactor must be at least 1")
+		}
+	}
+	if cfg.GossipMode != GossipPush && cfg.GossipMode != GossipPushPull {
+		return fmt.Errorf("config: unknown gossip mode %d", cfg.GossipMode)
+	}
+	if len(cfg.ChainID) > MaxCertChainID {
+		return fmt.Errorf("config: ChainID longer than %d bytes", MaxCertChainID)
+	}
+	// The RPC API has admin calls and no authentication
+	if cfg.RPCAddr != "" && !isLoopbackAddr(cfg.RPCAddr) {
+		return fmt.Errorf("config: RPCAddr %s is not a loopback address", cfg.RPCAddr)
+	}
+	return nil
+}
+
+// NewValidator creates a validator from a validated cfg. It is started with
+// Start.
+func NewValidator(cfg Config) (*Validator, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	val := &Validator{}
+	val.log = cfg.Logger
+	if val.log == nil {
+		val.log = logrus.New()
+		val.log.SetLevel(logrus.InfoLevel)
+	}
+	nodeKey := cfg.NodeKey
+	if nodeKey == nil {
+		_, nodeKey = GenNodeKey()
+	}
+	val.observer = cfg.Observer
+	val.observerAddrs = cfg.ObserverAddrs
+	val.init(cfg.ID, cfg.BLS, cfg.PrivKey, nodeKey, cfg.UseCommitPrepare)
+	val.branchFactor = cfg.BranchFactor
+	val.epochLen = cfg.EpochLen
+	val.schedule = cfg.Schedule
+	val.gossipMode = cfg.GossipMode
+	val.transport = cfg.Transport
+	val.store = cfg.Store
+	val.chainID = cfg.ChainID
+	val.metricsAddr = cfg.MetricsAddr
+	val.rpcAddr = cfg.RPCAddr
+	val.publicRPCAddr = cfg.PublicRPCAddr
+	if cfg.EventLog != nil {
+		val.SetEventLog(cfg.EventLog)
+	}
+
+	val.setValSet(cfg.ValAddrs, cfg.ValPubKeys)
+	if cfg.ValNodeKeys != nil {
+		if err := val.SetNodeKeys(cfg.ValNodeKeys, cfg.ValNodeKeySigs); err != nil {
+			return nil, err
+		}
+	}
+	return val, nil
+}
@@ -0,0 +1,83 @@
+package PairBFT
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+func TestConfigValidate(t *testing.T) {
+	numVals := 4
+	bls := &BLS{}
+	bls.Init()
+	cfg := Config{
+		BLS:           bls,
+		ValAddrs:      genLocalValidatorAddresses(numVals),
+		ValPubKeys:    make([]*pbc.Element, numVals),
+		ValPubKeySigs: make([]*pbc.Element, numVals),
+		EpochLen:      50 * time.Millisecond,
+		BranchFactor:  1,
+	}
+	privKeys := make([]*pbc.Element, numVals)
+	for i := 0; i < numVals; i++ {
+		privKeys[i], cfg.ValPubKeys[i], cfg.ValPubKeySigs[i] = GenValidatorKeys(bls)
+	}
+	cfg.ID = 2
+	cfg.PrivKey = privKeys[2]
+	val, err := NewValidator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	swapped := []*pbc.Element{cfg.ValPubKeySigs[1], cfg.ValPubKeySigs[0], cfg.ValPubKeySigs[2], cfg.ValPubKeySigs[3]}
+	if val.SetValSet(cfg.ValAddrs, cfg.ValPubKeys, swapped) == nil {
+		t.Error("Invalid proofs of possession accepted.")
+	}
+	if err := val.SetValSet(cfg.ValAddrs, cfg.ValPubKeys, cfg.ValPubKeySigs); err != nil {
+		t.Error(err)
+	}
+
+	bad := []func(cfg *Config){
+		func(cfg *Config) { cfg.ID = numVals },
+		func(cfg *Config) { cfg.PrivKey = privKeys[1] },
+		func(cfg *Config) { cfg.ValAddrs = cfg.ValAddrs[1:] },
+		func(cfg *Config) { cfg.ValPubKeySigs = []*pbc.Element{cfg.ValPubKeySigs[1], cfg.ValPubKeySigs[0], cfg.ValPubKeySigs[2], cfg.ValPubKeySigs[3]} },
+		func(cfg *Config) { cfg.EpochLen = 0 },
+		func(cfg *Config) { cfg.Schedule = &GossipSchedule{MinInterval: time.Second, MaxInterval: time.Millisecond} },
+		func(cfg *Config) { cfg.GossipMode = 5 },
+		func(cfg *Config) { cfg.Observer = true },
+		func(cfg *Config) { cfg.Observer, cfg.PrivKey, cfg.ID = true, nil, numVals },
+		func(cfg *Config) { cfg.RPCAddr = "0.0.0.0:8545" },
+		func(cfg *Config) { cfg.RPCAddr = ":8545" },
+		func(cfg *Config) { cfg.ChainID = strings.Repeat("x", MaxCertChainID+1) },
+	}
+	for i, change := range bad {
+		c := cfg
+		change(&c)
+		if _, err := NewValidator(c); err == nil {
+			t.Error("Bad config", i, "accepted")
+		}
+	}
+	c := cfg
+	c.RPCAddr = "localhost:8545"
+	if err := c.Validate(); err != nil {
+		t.Error("Loopback RPCAddr rejected:", err)
+	}
+}
+
+func TestMemBlockStore(t *testing.T) {
+	s := &MemBlockStore{}
+	s.Init()
+	if _, err := s.Get(1); err != ErrBlockNotFound {
+		t.Error("Expected ErrBlockNotFound, got", err)
+	}
+	s.Put(&FinalizedBlock{Height: 1, Hash: []byte{1}})
+	s.Put(&FinalizedBlock{Height: 3, Hash: []byte{3}})
+	if s.Height() != 3 {
+		t.Error("Wrong height:", s.Height())
+	}
+	if b, err := s.Get(3); err != nil || b.Hash[0] != 3 {
+		t.Error("Wrong block:", b, err)
+	}
+}
@@ -2,11 +2,7 @@ package PairBFT
 
 import (
 	"crypto/sha256"
-	"github.com/sirupsen/logrus"
-)
-
-const (
-	logLevel = logrus.DebugLevel
+	"time"
 )
 
 const (
@@ -23,6 +19,8 @@ const (
 	MsgTypeCommitPrepare
 	MsgTypeSyncRequest
 	MsgTypeSyncResponse
+	MsgTypeFragment
+	MsgTypeAddrRecord
 )
 
 const (
@@ -35,7 +33,28 @@ const (
 )
 
 const (
-	MaxPacketSize = 4096
+	MaxPacketSize      = 4096
+	TransportQueueSize = 1024
+)
+
+const (
+	GossipPush     = iota // send the current aggregate, never answer
+	GossipPushPull        // also answer a peer whose aggregate is worse than ours
+)
+
+const (
+	AddrGossipEpochs = 10
+)
+
+const (
+	lenFragmentID       = 8
+	lenFragmentIndex    = 2
+	LenFragmentHeader   = LenMsgType + lenFragmentID + 2*lenFragmentIndex
+	MaxFragmentPayload  = MaxPacketSize - LenFragmentHeader
+	MaxFragments        = 1024
+	MaxMsgSize          = MaxFragments * MaxFragmentPayload
+	FragmentTimeout     = 2 * time.Second
+	MaxReassemblyMemory = 16 * 1024 * 1024
 )
 
 const (
@@ -43,4 +62,6 @@ const (
 	NoncePrepare       = "Prepare2441491481761971821351735919983126136878719861412001628783236206511298664521024082"
 	NonceCommitPrepare = "CommitPrepare561102092383925104549199356790242961851017412821315924618619041207140122342062379"
 	NoncePubKey        = "PublicKey184294491111767962128176251109214135170276146201125206161342435891271641642430140"
+	NonceNodeKey       = "NodeKey2181046617922316411293159171832402052471382253012418810517672193118462197316021135"
+	NonceAddrRecord    = "AddrRecord1172302091462318514924021510918325421137104231197206761398917722811313420215"
 )
@@ -0,0 +1,128 @@
+package PairBFT
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+)
+
+// Besides random gossip, votes can flow along a fixed structure:
+// in star mode every validator sends to the proposer of the current height,
+// which broadcasts its aggregate (the quorum certificate once it has one) to
+// everybody; in tree mode the validators form a per-height tree rooted at the
+// proposer, and each phase runs in two steps: the message that opens it is
+// broadcast down the tree, then the votes are aggregated up to the root, whose
+// quorum opens the next phase.
+const (
+	DisseminateGossip = iota
+	DisseminateStar
+	DisseminateTree
+)
+
+// treeOrder returns the validators in tree order for the given height: the
+// proposer first, followed by a permutation derived from the height.
+func treeOrder(blockHeight uint64, numVals int) []int {
+	proposerID := getProposerID(blockHeight, numVals)
+	b := make([]byte, LenBlockHeight)
+	binary.LittleEndian.PutUint64(b, blockHeight)
+	h := sha256.Sum256(b)
+	r := rand.New(rand.NewSource(int64(binary.LittleEndian.Uint64(h[:]))))
+
+	order := make([]int, 0, numVals)
+	order = append(order, proposerID)
+	for _, i := range r.Perm(numVals) {
+		if i != proposerID {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+func treeArity(branchFactor int) int {
+	if branchFactor < 2 {
+		return 2
+	}
+	return branchFactor
+}
+
+// treeNeighbors returns the parent (-1 for the root) and the children of id.
+func treeNeighbors(blockHeight uint64, numVals int, arity int, id int) (int, []int) {
+	order := treeOrder(blockHeight, numVals)
+	pos := 0
+	for i, v := range order {
+		if v == id {
+			pos = i
+		}
+	}
+
+	parent := -1
+	if pos > 0 {
+		parent = order[(pos-1)/arity]
+	}
+	var children []int
+	for c := arity*pos + 1; c <= arity*pos+arity && c < numVals; c++ {
+		children = append(children, order[c])
+	}
+	return parent, children
+}
+
+// treeRcpts returns the recipients of id in tree mode, given the signers of
+// its aggregate. Every validator but the root sends its aggregate up to its
+// parent. A validator passes the current phase down only to the children that
+// have not signed it yet, so once the subtrees have answered the traffic
+// flows up only, until the root reaches a quorum and opens the next phase.
+// The quorum that finalizes a block opens the next height, whose tree is
+// rooted at the next proposer: it goes there directly.
+func treeRcpts(blockHeight uint64, final bool, numVals int, arity int, id int, counters []uint32) []int {
+	if final {
+		if next := getProposerID(blockHeight+1, numVals); next != id {
+			return []int{next}
+		}
+		return nil
+	}
+	parent, children := treeNeighbors(blockHeight, numVals, arity, id)
+	var rcpts []int
+	for _, c := range children {
+		if counters[c] == 0 {
+			rcpts = append(rcpts, c)
+		}
+	}
+	if parent >= 0 {
+		rcpts = append(rcpts, parent)
+	}
+	return rcpts
+}
+
+// structuredRcpts returns the recipients of this epoch in star or tree mode.
+func (val *Validator) structuredRcpts() []int {
+	numVals := len(val.valAddrSet)
+	val.stateMutex.Lock()
+	blockHeight := val.blockHeight
+	final := val.state == StateFinal || val.state == StateFinalPrepared
+	var counters []uint32
+	if val.aggSig != nil {
+		counters = append(counters, val.aggSig.counters...)
+	}
+	val.stateMutex.Unlock()
+
+	switch val.dissemination {
+	case DisseminateStar:
+		proposerID := getProposerID(blockHeight, numVals)
+		if proposerID != val.id {
+			return []int{proposerID}
+		}
+		rcpts := make([]int, 0, numVals-1)
+		for i := 0; i < numVals; i++ {
+			if i != val.id {
+				rcpts = append(rcpts, i)
+			}
+		}
+		return rcpts
+	case DisseminateTree:
+		if counters == nil {
+			return nil
+		}
+		return treeRcpts(blockHeight, final, numVals, treeArity(val.GossipParams().BranchFactor), val.id, counters)
+	}
+	return nil
+}
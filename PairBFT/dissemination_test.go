@@ -0,0 +1,91 @@
+package PairBFT
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTreeNeighbors(t *testing.T) {
+	for _, numVals := range []int{4, 10, 41} {
+		for blockHeight := uint64(1); blockHeight < 5; blockHeight++ {
+			arity := 3
+			numRoots := 0
+			numChildren := 0
+			for id := 0; id < numVals; id++ {
+				parent, children := treeNeighbors(blockHeight, numVals, arity, id)
+				if parent < 0 {
+					numRoots++
+					if id != getProposerID(blockHeight, numVals) {
+						t.Error("Root is not the proposer.")
+					}
+				}
+				if len(children) > arity {
+					t.Error("Too many children.")
+				}
+				for _, c := range children {
+					p, _ := treeNeighbors(blockHeight, numVals, arity, c)
+					if p != id {
+						t.Error("Child ", c, " does not point back to parent ", id)
+					}
+				}
+				numChildren += len(children)
+			}
+			if numRoots != 1 || numChildren != numVals-1 {
+				t.Error("Not a tree: ", numRoots, " roots, ", numChildren, " edges.")
+			}
+		}
+	}
+}
+
+func TestTreeRcpts(t *testing.T) {
+	numVals := 10
+	arity := 3
+	blockHeight := uint64(3)
+	root := getProposerID(blockHeight, numVals)
+	_, children := treeNeighbors(blockHeight, numVals, arity, root)
+
+	// The root passes the phase down to its children until they have signed
+	counters := make([]uint32, numVals)
+	counters[root] = 1
+	if rcpts := treeRcpts(blockHeight, false, numVals, arity, root, counters); len(rcpts) != len(children) {
+		t.Error("Root does not broadcast down: ", rcpts)
+	}
+	for _, c := range children {
+		counters[c] = 1
+	}
+	if rcpts := treeRcpts(blockHeight, false, numVals, arity, root, counters); len(rcpts) != 0 {
+		t.Error("Root sends to children that have signed: ", rcpts)
+	}
+
+	// A child sends up to the root in any case
+	child := children[0]
+	if rcpts := treeRcpts(blockHeight, false, numVals, arity, child, counters); len(rcpts) == 0 || rcpts[len(rcpts)-1] != root {
+		t.Error("Child does not send up: ", rcpts)
+	}
+
+	// A finalized block opens the next height, at its proposer
+	next := getProposerID(blockHeight+1, numVals)
+	if rcpts := treeRcpts(blockHeight, true, numVals, arity, root, counters); len(rcpts) != 1 || rcpts[0] != next {
+		t.Error("Final quorum not sent to the next proposer: ", rcpts)
+	}
+}
+
+func TestDisseminationModes(t *testing.T) {
+	numVals := 40
+	bf := 3
+	numHeights := uint64(5)
+	maxRounds := 1000
+
+	names := []string{"gossip", "star", "tree"}
+	for mode, name := range names {
+		vals := genValidators(numVals, bf, time.Millisecond, false)
+		for i := 0; i < numVals; i++ {
+			vals[i].SetDissemination(mode)
+		}
+		rounds, bytesSent := roundsAndBytesToFinality(vals, numHeights, maxRounds)
+		t.Log(name, ": ", rounds, " rounds, ", bytesSent, " bytes to finalize ", numHeights, " blocks")
+		if rounds >= maxRounds {
+			t.Error(name, " dissemination did not reach finality.")
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package PairBFT
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Every state transition of a validator is logged with structured fields and,
+// if an event log is set, written to it as one JSON object per line.
+type (
+	Event struct {
+		Time      time.Time `json:"time"`
+		Validator int       `json:"validator"`
+		Event     string    `json:"event"`
+		Height    uint64    `json:"height"`
+		State     string    `json:"state"`
+		Hash      string    `json:"hash"`
+		Counters  []uint32  `json:"counters,omitempty"`
+		Signers   int       `json:"signers"`
+	}
+
+	eventLog struct {
+		mutex sync.Mutex
+		enc   *json.Encoder
+	}
+)
+
+func StateName(state int) string {
+	switch state {
+	case StateIdle:
+		return "Idle"
+	case StatePrepared:
+		return "Prepared"
+	case StateCommitted:
+		return "Committed"
+	case StateFinal:
+		return "Final"
+	case StateCommitPrepared:
+		return "CommitPrepared"
+	case StateFinalPrepared:
+		return "FinalPrepared"
+	}
+	return "Unknown"
+}
+
+// SetLogLevel changes the level of the validator's logger at runtime.
+func (val *Validator) SetLogLevel(level logrus.Level) {
+	val.log.SetLevel(level)
+}
+
+// SetLogOutput redirects the validator's logger.
+func (val *Validator) SetLogOutput(w io.Writer) {
+	val.log.SetOutput(w)
+}
+
+// SetEventLog writes the state transitions to w as JSON lines; nil stops it.
+func (val *Validator) SetEventLog(w io.Writer) {
+	val.events.mutex.Lock()
+	defer val.events.mutex.Unlock()
+	if w == nil {
+		val.events.enc = nil
+		return
+	}
+	val.events.enc = json.NewEncoder(w)
+}
+
+// stateFields describes the validator state; stateMutex must be held.
+func (val *Validator) stateFields() logrus.Fields {
+	return logrus.Fields{
+		"validator": val.id,
+		"height":    val.blockHeight,
+		"state":     StateName(val.state),
+		"phase":     MsgTypeName(stateMsgType(val.state)),
+	}
+}
+
+// transition logs that the validator reached the given step for the block of
+// the given height and hash, with the aggregate sig. It is called with
+// stateMutex held.
+func (val *Validator) transition(event string, height uint64, hash []byte, sig *AggSig) {
+	e := Event{
+		Time:      time.Now(),
+		Validator: val.id,
+		Event:     event,
+		Height:    height,
+		State:     StateName(val.state),
+		Hash:      hex.EncodeToString(hash),
+	}
+	if sig != nil {
+		e.Counters = sig.counters
+		e.Signers = sig.NumSigners()
+	}
+
+	fields := val.stateFields()
+	fields["height"] = height
+	fields["counters"] = e.Counters
+	val.log.WithFields(fields).Info(event)
+
+	val.events.mutex.Lock()
+	defer val.events.mutex.Unlock()
+	if val.events.enc != nil {
+		if err := val.events.enc.Encode(&e); err != nil {
+			val.log.Debug("Failed to write event: ", err)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package PairBFT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestEventLog(t *testing.T) {
+	val := &Validator{id: 3, log: logrus.New()}
+	var logOut, events bytes.Buffer
+	val.SetLogOutput(&logOut)
+	val.SetLogLevel(logrus.WarnLevel)
+	val.SetEventLog(&events)
+
+	sig := &AggSig{counters: []uint32{1, 0, 1, 1}}
+	val.blockHeight = 7
+	val.state = StatePrepared
+	val.transition("Prepared", 7, []byte{0xab}, sig)
+	val.state = StateCommitted
+	val.transition("Committed", 7, []byte{0xab}, sig)
+
+	if logOut.Len() != 0 {
+		t.Error("Logged below the level:", logOut.String())
+	}
+
+	var got []Event
+	scanner := bufio.NewScanner(&events)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, e)
+	}
+	if len(got) != 2 {
+		t.Fatal("Expected 2 events, got", len(got))
+	}
+	e := got[1]
+	if e.Validator != 3 || e.Event != "Committed" || e.Height != 7 || e.State != "Committed" || e.Hash != "ab" || e.Signers != 3 {
+		t.Error("Wrong event:", e)
+	}
+
+	val.SetLogLevel(logrus.InfoLevel)
+	val.transition("Finalized", 7, []byte{0xab}, sig)
+	if logOut.Len() == 0 {
+		t.Error("Nothing logged at InfoLevel")
+	}
+}
@@ -0,0 +1,194 @@
+package PairBFT
+
+import (
+	"sync"
+
+	"github.com/Nik-U/pbc"
+	"github.com/sirupsen/logrus"
+)
+
+// When an aggregate fails verification, the validator looks for the signers
+// whose contribution may be invalid. It keeps the verified individual
+// signatures it has seen for the hashes it currently verifies against; the
+// signers of the aggregate without one are the suspects. An invalid
+// aggregate is not signed evidence against any of them, since whoever
+// relayed it may have built it, so the report names the relaying peer too.
+// Honest validators relay only aggregates they have verified, so the peer is
+// the culprit if the transport authenticated it, see SecureTransport. Each
+// fault of a peer is investigated once per phase.
+type (
+	FaultReport struct {
+		BlockHeight uint64   `json:"blockHeight"`
+		MsgType     string   `json:"msgType"`
+		Aggregate   string   `json:"aggregate"` // "PSig" or "CSig"
+		Counters    []uint32 `json:"counters"`
+		// The peer the aggregate was received from, -1 if unknown
+		Peer int `json:"peer"`
+		// Signers whose contribution could not be checked on its own
+		Suspects []int `json:"suspects"`
+		// The authenticated peer that sent the invalid aggregate, -1 if the
+		// transport does not authenticate peers
+		Culprit int `json:"culprit"`
+	}
+
+	sigStore struct {
+		mutex    sync.Mutex
+		sigs     map[*pbc.Pairer]map[int]*pbc.Element
+		reported map[faultKey]bool // faults investigated since the last retain
+	}
+
+	faultKey struct {
+		peer        int
+		blockHeight uint64
+		msgType     byte
+		aggregate   string
+		hash        string
+	}
+)
+
+const (
+	MaxFaultReports = 64
+	maxStoredHashes = 16
+	maxFaultKeys    = 1024
+)
+
+func (s *sigStore) add(hash *pbc.Pairer, id int, sig *pbc.Element) {
+	if hash == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.sigs == nil {
+		s.sigs = make(map[*pbc.Pairer]map[int]*pbc.Element)
+	}
+	sigs, ok := s.sigs[hash]
+	if !ok {
+		if len(s.sigs) >= maxStoredHashes {
+			return
+		}
+		sigs = make(map[int]*pbc.Element)
+		s.sigs[hash] = sigs
+	}
+	if _, ok := sigs[id]; !ok {
+		sigs[id] = sig
+	}
+}
+
+func (s *sigStore) get(hash *pbc.Pairer) map[int]*pbc.Element {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sigs := make(map[int]*pbc.Element)
+	for id, sig := range s.sigs[hash] {
+		sigs[id] = sig
+	}
+	return sigs
+}
+
+// firstReport tells whether the fault identified by key is to be
+// investigated, and if so records that it was.
+func (s *sigStore) firstReport(key faultKey) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.reported == nil {
+		s.reported = make(map[faultKey]bool)
+	}
+	if s.reported[key] || len(s.reported) >= maxFaultKeys {
+		return false
+	}
+	s.reported[key] = true
+	return true
+}
+
+// retain forgets the signatures of all hashes but the given ones, and which
+// faults were investigated.
+func (s *sigStore) retain(hashes ...*pbc.Pairer) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for hash := range s.sigs {
+		keep := false
+		for _, h := range hashes {
+			keep = keep || h == hash
+		}
+		if !keep {
+			delete(s.sigs, hash)
+		}
+	}
+	s.reported = nil
+}
+
+// retainSingles remembers the signatures of msg that come from one signer.
+// It is called only once msg has verified, so a forged single cannot take
+// the place of the valid one.
+func (val *Validator) retainSingles(msg *Msg) {
+	if id, ok := singleSigner(msg.PSig); ok {
+		val.singles.add(msg.pPairer, id, msg.PSig.sig)
+	}
+	if id, ok := singleSigner(msg.CSig); ok {
+		val.singles.add(msg.cPairer, id, msg.CSig.sig)
+	}
+}
+
+// reportFault localizes the faults of msg, which failed verification, and
+// logs them, unless the peer of msg has been investigated for the same hash.
+func (val *Validator) reportFault(msg *Msg) {
+	check := func(name string, sig *AggSig, hash *pbc.Pairer) {
+		key := faultKey{msg.peer, msg.blockHeight, msg.msgType, name, string(msg.hash)}
+		if hash == nil || !val.singles.firstReport(key) ||
+			val.bls.VerifyPreprocessed(hash, sig.sig, val.aggKeys.AggKey(sig.counters)) {
+			return
+		}
+		report := val.localizeFault(sig, hash)
+		report.Peer = msg.peer
+		if _, ok := val.transport.(*SecureTransport); ok && msg.peer >= 0 {
+			report.Culprit = msg.peer
+		}
+		report.BlockHeight = msg.blockHeight
+		report.MsgType = MsgTypeName(msg.msgType)
+		report.Aggregate = name
+
+		val.faultMutex.Lock()
+		if len(val.faultReports) >= MaxFaultReports {
+			val.faultReports = val.faultReports[1:]
+		}
+		val.faultReports = append(val.faultReports, report)
+		val.faultMutex.Unlock()
+
+		val.log.WithFields(logrus.Fields{
+			"blockHeight": report.BlockHeight,
+			"msgType":     report.MsgType,
+			"aggregate":   report.Aggregate,
+			"counters":    report.Counters,
+			"peer":        report.Peer,
+			"suspects":    report.Suspects,
+			"culprit":     report.Culprit,
+		}).Error("Invalid aggregate signature")
+	}
+	check("PSig", msg.PSig, msg.pPairer)
+	if msg.msgType == MsgTypeCommit || msg.blockHeight > 1 {
+		check("CSig", msg.CSig, msg.cPairer)
+	}
+}
+
+// FaultReports returns the most recent reports on invalid aggregates.
+func (val *Validator) FaultReports() []FaultReport {
+	val.faultMutex.Lock()
+	defer val.faultMutex.Unlock()
+	return append([]FaultReport(nil), val.faultReports...)
+}
+
+// localizeFault lists the signers of sig, which fails to verify against
+// hash, whose individual signature is not known to be valid.
+func (val *Validator) localizeFault(sig *AggSig, hash *pbc.Pairer) FaultReport {
+	report := FaultReport{
+		Counters: append([]uint32(nil), sig.counters...),
+		Suspects: []int{},
+		Culprit:  -1,
+	}
+	singles := val.singles.get(hash)
+	for i, counter := range sig.counters {
+		if counter != 0 && singles[i] == nil {
+			report.Suspects = append(report.Suspects, i)
+		}
+	}
+	return report
+}
@@ -0,0 +1,118 @@
+package PairBFT
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Nik-U/pbc"
+)
+
+func TestLocalizeFault(t *testing.T) {
+	numVals := 7
+	bls := &BLS{}
+	bls.Init()
+	privKeys := make([]*pbc.Element, numVals)
+	pubKeys := make([]*pbc.Element, numVals)
+	for i := range privKeys {
+		privKeys[i], pubKeys[i] = bls.GenKey()
+	}
+	val := &Validator{bls: bls, valPubKeySet: pubKeys}
+	val.aggKeys.Init(bls, pubKeys, AggKeyCacheSize)
+
+	block := getBlockHash([]byte("block"), make([]byte, LenHash))
+	hash := getNoncedHash(block, NoncePrepare)
+	wrongHash := getNoncedHash(block, NonceCommit)
+	pairer := bls.PreprocessHash(hash)
+
+	// Validator 2 contributes a signature on the wrong hash
+	sig := genTestAggSig(bls, hash, privKeys, 0, 1, 3, 4, 5, 5)
+	sig.sig.ThenMul(bls.SignHash(wrongHash, privKeys[2]))
+	sig.counters[2] = 1
+
+	for _, i := range []int{0, 1, 3, 4, 5} {
+		val.singles.add(pairer, i, bls.SignHash(hash, privKeys[i]))
+	}
+	report := val.localizeFault(sig, pairer)
+	if !reflect.DeepEqual(report.Suspects, []int{2}) {
+		t.Error("Wrong report:", report)
+	}
+
+	// Without the individual signature of 4, it is a suspect too
+	val.singles.retain()
+	for _, i := range []int{0, 1, 3, 5} {
+		val.singles.add(pairer, i, bls.SignHash(hash, privKeys[i]))
+	}
+	report = val.localizeFault(sig, pairer)
+	if !reflect.DeepEqual(report.Suspects, []int{2, 4}) {
+		t.Error("Wrong report:", report)
+	}
+}
+
+func TestRetainVerifiedSingles(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, time.Millisecond, false)
+	proposerID := getProposerID(1, numVals)
+	vals[proposerID].proposeBlock(1)
+	rcpt := (proposerID + 1) % numVals
+	val := vals[rcpt]
+	data := vals[proposerID].genMsgData(rcpt)
+
+	decode := func() *Msg {
+		msg := &Msg{}
+		msg.Init(val.bls, numVals, MsgTypeUnknown)
+		msg.SetBytes(data)
+		return msg
+	}
+
+	// A forged proposal arriving first must not shadow the valid one
+	forged := decode()
+	forged.PSig.sig = val.bls.SignHash(getNoncedHash(forged.hash, NoncePrepare), val.privKey)
+	msg := decode()
+	if verified := val.verifyMsgs([]*Msg{forged, msg}); verified[0] || !verified[1] {
+		t.Fatal("Wrong verification results:", verified)
+	}
+	single := val.singles.get(msg.pPairer)[proposerID]
+	if single == nil || !single.Equals(msg.PSig.sig) {
+		t.Error("Verified single not retained.")
+	}
+}
+
+func TestReportFaultOncePerPeer(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, time.Millisecond, false)
+	proposerID := getProposerID(1, numVals)
+	vals[proposerID].proposeBlock(1)
+	rcpt := (proposerID + 1) % numVals
+	val := vals[rcpt]
+	data := vals[proposerID].genMsgData(rcpt)
+
+	forge := func(peer int) {
+		msg, _ := val.decodeMsg(peer, data)
+		msg.PSig.sig = val.bls.SignHash(getNoncedHash(msg.hash, NoncePrepare), val.privKey)
+		if val.verifyMsg(msg) {
+			t.Fatal("Forged message verified.")
+		}
+	}
+	// Only the first fault of each peer is investigated
+	other := (rcpt + 1) % numVals
+	forge(proposerID)
+	forge(proposerID)
+	forge(other)
+	reports := val.FaultReports()
+	if len(reports) != 2 || reports[0].Peer != proposerID || reports[1].Peer != other {
+		t.Error("Wrong reports:", reports)
+	}
+	if !reflect.DeepEqual(reports[0].Suspects, []int{proposerID}) || reports[0].Culprit != -1 {
+		t.Error("Wrong report:", reports[0])
+	}
+
+	// A peer authenticated by the transport is to blame for what it sends
+	val.transport = &SecureTransport{}
+	last := (rcpt + 2) % numVals
+	forge(last)
+	reports = val.FaultReports()
+	if len(reports) != 3 || reports[2].Culprit != last {
+		t.Error("Wrong reports:", reports)
+	}
+}
@@ -0,0 +1,185 @@
+package PairBFT
+
+import (
+	"encoding/binary"
+	"sync"
+	"time"
+)
+
+// A message longer than MaxPacketSize is split into fragments of the form
+// MsgTypeFragment | message id | fragment index | fragment count | payload.
+// The receiver keeps the fragments of each (source, message id) pair until
+// all of them have arrived, the timeout expires, or memory runs short. The
+// memory charged to a message includes a slot for each expected fragment, so
+// that spoofed fragment counts cannot allocate beyond the limit.
+
+type (
+	fragmentKey struct {
+		src string
+		id  uint64
+	}
+
+	fragmentBuffer struct {
+		parts    [][]byte
+		received int
+		size     int // charged to Reassembler.memory
+		deadline time.Time
+	}
+
+	Reassembler struct {
+		mutex     sync.Mutex
+		buffers   map[fragmentKey]*fragmentBuffer
+		memory    int
+		timeout   time.Duration
+		maxMemory int
+	}
+)
+
+const (
+	fragmentSlotSize = 24 // a slice header in fragmentBuffer.parts
+)
+
+func fragmentData(data []byte, msgID uint64) [][]byte {
+	if len(data) <= MaxPacketSize {
+		return [][]byte{data}
+	}
+
+	count := (len(data) + MaxFragmentPayload - 1) / MaxFragmentPayload
+	packets := make([][]byte, count)
+	for i := 0; i < count; i++ {
+		start := i * MaxFragmentPayload
+		end := start + MaxFragmentPayload
+		if end > len(data) {
+			end = len(data)
+		}
+		b := make([]byte, LenFragmentHeader+end-start)
+		j := 0
+		b[j] = MsgTypeFragment
+		j += LenMsgType
+		binary.LittleEndian.PutUint64(b[j:], msgID)
+		j += lenFragmentID
+		binary.LittleEndian.PutUint16(b[j:], uint16(i))
+		j += lenFragmentIndex
+		binary.LittleEndian.PutUint16(b[j:], uint16(count))
+		j += lenFragmentIndex
+		copy(b[j:], data[start:end])
+		packets[i] = b
+	}
+	return packets
+}
+
+func (r *Reassembler) Init(timeout time.Duration, maxMemory int) {
+	r.buffers = make(map[fragmentKey]*fragmentBuffer)
+	r.memory = 0
+	r.timeout = timeout
+	r.maxMemory = maxMemory
+}
+
+// Add stores one fragment received from src. It returns the reassembled
+// message once the last missing fragment arrives, and nil otherwise.
+func (r *Reassembler) Add(src string, packet []byte, now time.Time) []byte {
+	if len(packet) <= LenFragmentHeader || packet[0] != MsgTypeFragment {
+		return nil
+	}
+	j := LenMsgType
+	id := binary.LittleEndian.Uint64(packet[j:])
+	j += lenFragmentID
+	index := int(binary.LittleEndian.Uint16(packet[j:]))
+	j += lenFragmentIndex
+	count := int(binary.LittleEndian.Uint16(packet[j:]))
+	j += lenFragmentIndex
+	payload := packet[j:]
+	if count < 2 || count > MaxFragments || index >= count {
+		return nil
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.expire(now)
+
+	key := fragmentKey{src, id}
+	buf, ok := r.buffers[key]
+	charge := len(payload)
+	if !ok {
+		charge += count * fragmentSlotSize
+	} else if len(buf.parts) != count || buf.parts[index] != nil {
+		return nil
+	}
+
+	for r.memory+charge > r.maxMemory {
+		if !r.evictOldest(key) {
+			return nil
+		}
+	}
+	if !ok {
+		buf = &fragmentBuffer{
+			parts:    make([][]byte, count),
+			size:     count * fragmentSlotSize,
+			deadline: now.Add(r.timeout),
+		}
+		r.memory += buf.size
+		r.buffers[key] = buf
+	}
+
+	part := make([]byte, len(payload))
+	copy(part, payload)
+	buf.parts[index] = part
+	buf.received++
+	buf.size += len(part)
+	r.memory += len(part)
+
+	if buf.received < count {
+		return nil
+	}
+
+	r.remove(key)
+	data := make([]byte, 0, buf.size)
+	for _, part := range buf.parts {
+		data = append(data, part...)
+	}
+	return data
+}
+
+func (r *Reassembler) Pending() int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return len(r.buffers)
+}
+
+func (r *Reassembler) expire(now time.Time) {
+	for key, buf := range r.buffers {
+		if now.After(buf.deadline) {
+			r.remove(key)
+		}
+	}
+}
+
+// evictOldest drops the incomplete message closest to its deadline, other than keep.
+func (r *Reassembler) evictOldest(keep fragmentKey) bool {
+	var (
+		oldest    fragmentKey
+		oldestBuf *fragmentBuffer
+	)
+	for key, buf := range r.buffers {
+		if key == keep {
+			continue
+		}
+		if oldestBuf == nil || buf.deadline.Before(oldestBuf.deadline) {
+			oldest, oldestBuf = key, buf
+		}
+	}
+	if oldestBuf == nil {
+		r.remove(keep)
+		return false
+	}
+	r.remove(oldest)
+	return true
+}
+
+func (r *Reassembler) remove(key fragmentKey) {
+	if buf, ok := r.buffers[key]; ok {
+		r.memory -= buf.size
+		delete(r.buffers, key)
+	}
+}
@@ -0,0 +1,102 @@
+package PairBFT
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestFragmentReassembly(t *testing.T) {
+	r := &Reassembler{}
+	r.Init(FragmentTimeout, MaxReassemblyMemory)
+
+	for _, size := range []int{1, MaxPacketSize, MaxPacketSize + 1, 10*MaxFragmentPayload + 7} {
+		data := make([]byte, size)
+		rand.Read(data)
+		packets := fragmentData(data, uint64(size))
+		if size <= MaxPacketSize {
+			if len(packets) != 1 || !bytes.Equal(packets[0], data) {
+				t.Error("Small message should not be fragmented.")
+			}
+			continue
+		}
+
+		rand.Shuffle(len(packets), func(i, j int) { packets[i], packets[j] = packets[j], packets[i] })
+		var out []byte
+		for i, packet := range packets {
+			if len(packet) > MaxPacketSize {
+				t.Error("Fragment exceeds packet size: ", len(packet))
+			}
+			out = r.Add("peer", packet, time.Now())
+			if out != nil && i != len(packets)-1 {
+				t.Error("Message completed early.")
+			}
+		}
+		if !bytes.Equal(out, data) {
+			t.Error("Reassembled message differs from original, size ", size)
+		}
+	}
+	if r.Pending() != 0 || r.memory != 0 {
+		t.Error("Reassembler retains completed messages.")
+	}
+}
+
+func TestFragmentTimeout(t *testing.T) {
+	r := &Reassembler{}
+	r.Init(time.Second, MaxReassemblyMemory)
+
+	data := make([]byte, 3*MaxFragmentPayload)
+	packets := fragmentData(data, 1)
+	now := time.Now()
+	r.Add("peer", packets[0], now)
+	r.Add("peer", packets[1], now)
+	if r.Pending() != 1 {
+		t.Error("Fragments not buffered.")
+	}
+	if r.Add("peer", packets[2], now.Add(2*time.Second)) != nil {
+		t.Error("Expired message reassembled.")
+	}
+}
+
+func TestFragmentMemoryLimit(t *testing.T) {
+	r := &Reassembler{}
+	r.Init(FragmentTimeout, 4*MaxFragmentPayload)
+
+	data := make([]byte, 3*MaxFragmentPayload)
+	now := time.Now()
+	first := fragmentData(data, 1)
+	second := fragmentData(data, 2)
+	r.Add("peer", first[0], now)
+	r.Add("peer", first[1], now)
+	r.Add("peer", second[0], now.Add(time.Millisecond))
+	r.Add("peer", second[1], now.Add(time.Millisecond))
+	if r.memory > 4*MaxFragmentPayload {
+		t.Error("Memory limit exceeded: ", r.memory)
+	}
+	if r.Add("peer", second[2], now.Add(time.Millisecond)) == nil {
+		t.Error("Newest message should survive eviction.")
+	}
+	if r.Add("peer", first[2], now) != nil {
+		t.Error("Evicted message reassembled.")
+	}
+}
+
+func TestFragmentSpoofedCounts(t *testing.T) {
+	r := &Reassembler{}
+	r.Init(FragmentTimeout, 4*MaxFragmentPayload)
+
+	// Single fragments of distinct messages announcing MaxFragments parts
+	now := time.Now()
+	packet := make([]byte, LenFragmentHeader+1)
+	packet[0] = MsgTypeFragment
+	binary.LittleEndian.PutUint16(packet[LenMsgType+lenFragmentID+lenFragmentIndex:], MaxFragments)
+	for id := uint64(0); id < 1000; id++ {
+		binary.LittleEndian.PutUint64(packet[LenMsgType:], id)
+		r.Add("peer", packet, now)
+	}
+	if r.memory > 4*MaxFragmentPayload || r.Pending()*MaxFragments*fragmentSlotSize > r.memory {
+		t.Error("Fragment slots not charged: ", r.Pending(), r.memory)
+	}
+}
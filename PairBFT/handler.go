@@ -2,34 +2,254 @@ package PairBFT
 
 import (
 	"bytes"
+	"encoding/hex"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 func (val *Validator) handleMsgData(data []byte) {
+	val.handleMsgDataFrom(-1, data)
+}
+
+// handleMsgDataFrom handles data received from validator peer, -1 if unknown
+func (val *Validator) handleMsgDataFrom(peer int, data []byte) {
+	if len(data) > 0 && data[0] == MsgTypeSyncResponse {
+		val.handleSyncResponse(peer, data)
+		return
+	}
+	msg, useful := val.decodeMsg(peer, data)
+	if msg == nil {
+		return
+	}
+	mark := val.getProgressMark()
+	verified := useful && val.handleMsg(msg)
+	val.afterMsg(peer, msg, verified, mark)
+}
+
+// decodeMsg parses data and runs the checks that need no pairing. It returns
+// nil if the data is to be dropped, and useful=false if the message cannot
+// change our state.
+func (val *Validator) decodeMsg(peer int, data []byte) (*Msg, bool) {
+	if len(data) > 0 {
+		switch data[0] {
+		case MsgTypeAddrRecord:
+			val.handleAddrRecord(data)
+			return nil, false
+		case MsgTypeSyncRequest:
+			val.handleSyncRequest(peer, data)
+			return nil, false
+		}
+	}
+
+	if len(data) != val.msgLen() {
+		atomic.AddUint64(&val.inboundCounters.malformed, 1)
+		return nil, false
+	}
 	numVals := len(val.valAddrSet)
 	msg := &Msg{}
 	msg.Init(val.bls, numVals, MsgTypeUnknown)
 	msg.SetBytes(data)
+	msg.peer = peer
+	if val.isMalformed(msg) {
+		atomic.AddUint64(&val.inboundCounters.malformed, 1)
+		return nil, false
+	}
+
+	if val.deferFuture(peer, msg, data) {
+		return nil, false
+	}
+
+	// A message that cannot change our state is not verified, but in push-pull
+	// mode its sender may still deserve an answer
+	if val.isObsolete(msg) {
+		atomic.AddUint64(&val.inboundCounters.useless, 1)
+		return msg, false
+	}
+	return msg, true
+}
+
+// handleMsg verifies and applies msg, and tells whether it was valid.
+func (val *Validator) handleMsg(msg *Msg) bool {
+	for val.verifyMsg(msg) {
+		if val.applyMsg(msg) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyMsg checks msg against the validator state and verifies its
+// signatures. Only the checks hold the state lock, not the pairings.
+func (val *Validator) verifyMsg(msg *Msg) bool {
+	return val.verifyMsgs([]*Msg{msg})[0]
+}
+
+// verifyMsgs is verifyMsg for several messages, whose signatures are checked
+// in a single batch.
+func (val *Validator) verifyMsgs(msgs []*Msg) []bool {
+	verified := make([]bool, len(msgs))
+	var (
+		items []BatchItem
+		first = make([]int, len(msgs))
+	)
+	for i, msg := range msgs {
+		val.stateMutex.Lock()
+		ok := val.checkMsg(msg)
+		msg.view = val.hashVersion
+		val.stateMutex.Unlock()
+		first[i] = len(items)
+		if ok && msg.hasQuorum() {
+			verified[i] = true
+			items = append(items, msg.SigItems(&val.aggKeys)...)
+		} else if ok {
+			atomic.AddUint64(&val.metrics.verifyFailures, 1)
+			val.logMessageVerificationFailure(msg)
+		}
+	}
+
+	start := time.Now()
+	valid := val.bls.BatchVerify(items)
+	if len(items) > 0 {
+		observeSince(&val.metrics.verifyTime, start)
+	}
+	for i, msg := range msgs {
+		if !verified[i] {
+			continue
+		}
+		end := len(items)
+		if i+1 < len(msgs) {
+			end = first[i+1]
+		}
+		for _, ok := range valid[first[i]:end] {
+			if !ok {
+				atomic.AddUint64(&val.metrics.verifyFailures, 1)
+				val.reportFault(msg)
+				val.logMessageVerificationFailure(msg)
+				verified[i] = false
+				break
+			}
+		}
+		if verified[i] {
+			val.retainSingles(msg)
+		}
+	}
+	return verified
+}
+
+// applyMsg updates the validator state with a verified message. It returns
+// false if the hashes msg was verified against have changed meanwhile, in
+// which case msg must be verified again.
+func (val *Validator) applyMsg(msg *Msg) bool {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
 
+	if msg.view != val.hashVersion {
+		msg.pPairer, msg.cPairer = nil, nil
+		return false
+	}
+	// The state may have moved on within the same height
+	if !val.checkMsg(msg) {
+		return true
+	}
 	switch msg.msgType {
 	case MsgTypePrepare:
-		val.handlePrepare(msg)
+		val.applyPrepare(msg)
 	case MsgTypeCommit:
-		val.handleCommit(msg)
+		val.applyCommit(msg)
 	case MsgTypeCommitPrepare:
-		val.handleCommitPrepare(msg)
+		val.applyCommitPrepare(msg)
 	}
+	return true
 }
 
-func (val *Validator) checkHashMismatch(msg *Msg) bool {
-	return val.state != StateIdle && val.blockHeight == msg.blockHeight && bytes.Compare(val.hash, msg.hash) != 0
+func (val *Validator) checkMsg(msg *Msg) bool {
+	switch msg.msgType {
+	case MsgTypePrepare:
+		return val.checkPrepare(msg)
+	case MsgTypeCommit:
+		return val.checkCommit(msg)
+	case MsgTypeCommitPrepare:
+		return val.checkCommitPrepare(msg)
+	}
+	return false
 }
 
-func (val *Validator) handlePrepare(msg *Msg) {
-	// It is possible to lock this mutex later, when we start to modify the validator states
-	// We lock it here for simplicity
+// afterMsg wakes up the gossip scheduler if msg changed our state, and in
+// push-pull mode answers a peer that is behind us. Only verified messages
+// tell the peer selector what their sender knows.
+func (val *Validator) afterMsg(peer int, msg *Msg, verified bool, mark progressMark) {
+	if verified && peer >= 0 && peer < len(val.valAddrSet) {
+		val.peerSelector.Observe(peer, msg.blockHeight, msg.msgType, msg.AggSig().counters)
+	}
+	if newMark := val.getProgressMark(); newMark != mark {
+		val.notifyProgress()
+		if newMark.blockHeight != mark.blockHeight || newMark.state != mark.state {
+			val.replayPending()
+		}
+	}
+
+	if val.GossipParams().Mode == GossipPushPull && peer >= 0 && peer < val.numPeers() && peer != val.id &&
+		val.knowsMore(msg) && val.mayAnswer(peer, msg) {
+		val.sendMsg(peer)
+	}
+}
+
+// mayAnswer guards push-pull replies against reflection: the peer is only
+// known from the packet source, which can be forged, so a validator peer must
+// have signed the aggregate it sent, and no peer gets more than one reply per
+// send epoch.
+func (val *Validator) mayAnswer(peer int, msg *Msg) bool {
+	if peer < len(val.valAddrSet) && msg.AggSig().counters[peer] == 0 {
+		return false
+	}
+	epoch := atomic.LoadUint64(&val.sendEpoch)
+	val.replyMutex.Lock()
+	defer val.replyMutex.Unlock()
+	if last, ok := val.lastReply[peer]; ok && last == epoch {
+		return false
+	}
+	val.lastReply[peer] = epoch
+	return true
+}
+
+// knowsMore tells whether our current message would bring the sender of msg
+// forward: it is about a later height or phase, or has strictly more signers.
+func (val *Validator) knowsMore(msg *Msg) bool {
 	val.stateMutex.Lock()
 	defer val.stateMutex.Unlock()
 
+	if val.state == StateIdle || val.blockHeight < msg.blockHeight {
+		return false
+	}
+	if val.blockHeight > msg.blockHeight {
+		return true
+	}
+	msgType := stateMsgType(val.state)
+	if msgType != msg.msgType {
+		return phaseRank(msgType) > phaseRank(msg.msgType)
+	}
+	return val.aggSig.NumSigners() > msg.AggSig().NumSigners()
+}
+
+func (val *Validator) handleAddrRecord(data []byte) {
+	rec := &AddrRecord{}
+	if err := rec.SetBytes(val.bls, data); err != nil {
+		val.log.Debug("Malformed address record: ", err)
+		return
+	}
+	if val.addrBook.Update(rec, val.bls, val.valPubKeySet) {
+		val.log.WithFields(logrus.Fields{"peer": rec.ValIndex, "addrs": rec.Addrs}).Info("Address record")
+		val.saveAddrBook()
+	}
+}
+
+func (val *Validator) checkHashMismatch(msg *Msg) bool {
+	return val.state != StateIdle && val.blockHeight == msg.blockHeight && bytes.Compare(val.hash, msg.hash) != 0
+}
+
+func (val *Validator) checkPrepare(msg *Msg) bool {
 	msgObsolete := false
 	if val.blockHeight > msg.blockHeight {
 		msgObsolete = true
@@ -38,19 +258,18 @@ func (val *Validator) handlePrepare(msg *Msg) {
 		msgObsolete = true
 	}
 	if msgObsolete {
-		return
+		return false
 	}
 
 	if msg.blockHeight > val.blockHeight+1 {
-		val.log.Panic("Not implemented: ", msg.blockHeight, " ", val.blockHeight)
-		// Todo: send sync request to the message sender
-		return
+		// Buffered by deferFuture
+		return false
 	}
 
 	if val.checkHashMismatch(msg) {
-		val.log.Panic("Hash mismatch: ", msg)
+		val.log.WithFields(logrus.Fields{"height": msg.blockHeight, "msgType": MsgTypeName(msg.msgType), "hash": hex.EncodeToString(msg.hash)}).Error("Hash mismatch")
 		// Todo: slash all validators contained in the message
-		return
+		return false
 	}
 
 	if val.state != StateIdle {
@@ -67,13 +286,10 @@ func (val *Validator) handlePrepare(msg *Msg) {
 	if msg.pPairer == nil {
 		msg.pPairer = val.bls.PreprocessHash(getNoncedHash(msg.hash, NoncePrepare))
 	}
+	return true
+}
 
-	if !msg.Verify(val.bls, val.valPubKeySet) {
-		val.logMessageVerificationFailure(msg)
-		val.log.Panic("Message verification failed.")
-		return
-	}
-
+func (val *Validator) applyPrepare(msg *Msg) {
 	if msg.blockHeight > 1 && msg.blockHeight > val.blockHeight && val.state != StateFinal {
 		val.aggSig = msg.CSig
 		val.finalizeBlock()
@@ -82,7 +298,7 @@ func (val *Validator) handlePrepare(msg *Msg) {
 	if val.state == StateIdle || msg.blockHeight > val.blockHeight {
 		val.prepareBlock(msg.blockHeight, msg.hash, msg.PSig, msg.CSig)
 	} else { // StatePrepared
-		val.aggSig.Aggregate(msg.PSig)
+		val.aggregate(msg.PSig)
 	}
 
 	if val.aggSig.ReachQuorum() {
@@ -90,12 +306,7 @@ func (val *Validator) handlePrepare(msg *Msg) {
 	}
 }
 
-func (val *Validator) handleCommit(msg *Msg) {
-	// It is possible to lock this mutex later, when we start to modify the validator states
-	// We lock it here for simplicity
-	val.stateMutex.Lock()
-	defer val.stateMutex.Unlock()
-
+func (val *Validator) checkCommit(msg *Msg) bool {
 	msgObsolete := false
 	if val.blockHeight > msg.blockHeight {
 		msgObsolete = true
@@ -104,23 +315,23 @@ func (val *Validator) handleCommit(msg *Msg) {
 		msgObsolete = true
 	}
 	if msgObsolete {
-		return
+		return false
 	}
 
 	if msg.blockHeight > val.blockHeight+1 {
-		val.log.Panic("Not implemented.")
-		// Todo: send sync request to the message sender
+		// Buffered by deferFuture
+		return false
 	}
 
 	if msg.blockHeight > 1 && msg.blockHeight > val.blockHeight && val.state != StateFinal {
-		val.log.Panic("Not implemented.")
-		// Todo: send sync request to the message sender, to retrieve the aggregate signature
+		// Buffered by deferFuture until we have the aggregate finalizing our block
+		return false
 	}
 
 	if val.checkHashMismatch(msg) {
-		val.log.Panic("Hash mismatch: ", msg)
+		val.log.WithFields(logrus.Fields{"height": msg.blockHeight, "msgType": MsgTypeName(msg.msgType), "hash": hex.EncodeToString(msg.hash)}).Error("Hash mismatch")
 		// Todo: slash all validators contained in the message
-		return
+		return false
 	}
 
 	if val.state != StateIdle && msg.blockHeight == val.blockHeight {
@@ -128,19 +339,16 @@ func (val *Validator) handleCommit(msg *Msg) {
 		msg.cPairer = val.cPairer
 	}
 	msg.Preprocess(val.bls, val.useCommitPrepare)
+	return true
+}
 
-	if !msg.Verify(val.bls, val.valPubKeySet) {
-		val.logMessageVerificationFailure(msg)
-		val.log.Panic("Message verification failed.", msg)
-		return
-	}
-
+func (val *Validator) applyCommit(msg *Msg) {
 	if val.state == StateIdle || msg.blockHeight > val.blockHeight {
 		val.commitBlock(msg.blockHeight, msg.hash, msg.CSig, msg.PSig)
 	} else if val.state == StatePrepared {
 		val.commitBlock(val.blockHeight, nil, msg.CSig, msg.PSig)
 	} else { // StateCommit
-		val.aggSig.Aggregate(msg.CSig)
+		val.aggregate(msg.CSig)
 	}
 
 	if val.aggSig.ReachQuorum() {
@@ -152,10 +360,7 @@ func (val *Validator) handleCommit(msg *Msg) {
 	}
 }
 
-func (val *Validator) handleCommitPrepare(msg *Msg) {
-	val.stateMutex.Lock()
-	defer val.stateMutex.Unlock()
-
+func (val *Validator) checkCommitPrepare(msg *Msg) bool {
 	msgObsolete := false
 	if val.blockHeight > msg.blockHeight {
 		msgObsolete = true
@@ -164,19 +369,18 @@ func (val *Validator) handleCommitPrepare(msg *Msg) {
 		msgObsolete = true
 	}
 	if msgObsolete {
-		return
+		return false
 	}
 
 	if msg.blockHeight > val.blockHeight+1 {
-		val.peerHeight = msg.blockHeight
-		val.log.Panic("Not implemented.")
-		// Todo: send sync request to the message sender
+		// Buffered by deferFuture
+		return false
 	}
 
 	if val.checkHashMismatch(msg) {
-		val.log.Panic("Hash mismatch: ", msg)
+		val.log.WithFields(logrus.Fields{"height": msg.blockHeight, "msgType": MsgTypeName(msg.msgType), "hash": hex.EncodeToString(msg.hash)}).Error("Hash mismatch")
 		// Todo: slash all validators contained in the message
-		return
+		return false
 	}
 
 	if val.state != StateIdle {
@@ -190,13 +394,10 @@ func (val *Validator) handleCommitPrepare(msg *Msg) {
 	if msg.pPairer == nil {
 		msg.pPairer = val.bls.PreprocessHash(getNoncedHash(msg.hash, NonceCommitPrepare))
 	}
+	return true
+}
 
-	if !msg.Verify(val.bls, val.valPubKeySet) {
-		val.logMessageVerificationFailure(msg)
-		val.log.Panic("Message verification failed.")
-		return
-	}
-
+func (val *Validator) applyCommitPrepare(msg *Msg) {
 	if msg.blockHeight > val.blockHeight && val.state != StateFinalPrepared {
 		val.aggSig = msg.CSig
 		val.finalizePrevBlock()
@@ -205,7 +406,7 @@ func (val *Validator) handleCommitPrepare(msg *Msg) {
 	if val.state == StateIdle || msg.blockHeight > val.blockHeight {
 		val.commitPrepareBlock(msg.blockHeight, msg.hash, msg.PSig, msg.CSig)
 	} else { // StatePrepared
-		val.aggSig.Aggregate(msg.PSig)
+		val.aggregate(msg.PSig)
 	}
 
 	if val.aggSig.ReachQuorum() {
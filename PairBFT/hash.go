@@ -20,6 +20,12 @@ func getBlockHash(blockData []byte, prevHash []byte) []byte {
 	return h[:]
 }
 
+// BlockHash returns the hash of a block from its data and the hash of its
+// parent, nil for block 1.
+func BlockHash(blockData []byte, prevHash []byte) []byte {
+	return getBlockHash(blockData, prevHash)
+}
+
 func getNoncedHash(hash []byte, nonce string) []byte {
 	dataToSign := make([]byte, LenHash+len(nonce))
 	copy(dataToSign, hash)
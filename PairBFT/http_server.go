@@ -0,0 +1,93 @@
+package PairBFT
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// The metrics and the RPC API, with the block stream at /blocks, are served
+// over HTTP while the validator runs. The public RPC API has the block
+// stream too.
+type (
+	httpEndpoint struct {
+		name     string
+		handler  http.Handler
+		listener net.Listener
+	}
+)
+
+const (
+	HTTPReadHeaderTimeout = 10 * time.Second
+	MaxRPCBodySize        = 1 << 20
+)
+
+// isLoopbackAddr tells whether addr, as given to net.Listen, only accepts
+// local connections.
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopbackHost(host)
+}
+
+// isLoopbackHost tells whether host, with or without a port, names the
+// local machine.
+func isLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// listenHTTP opens the listeners of the configured endpoints, so that a bad
+// address fails Start.
+func (val *Validator) listenHTTP() ([]*httpEndpoint, error) {
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("/metrics", val.MetricsHandler())
+	rpcMux := http.NewServeMux()
+	rpcMux.Handle("/", val.RPCHandler())
+	rpcMux.Handle("/blocks", val.BlockStreamHandler())
+	publicRPCMux := http.NewServeMux()
+	publicRPCMux.Handle("/", val.PublicRPCHandler())
+	publicRPCMux.Handle("/blocks", val.BlockStreamHandler())
+
+	var endpoints []*httpEndpoint
+	for _, e := range []struct {
+		name, addr string
+		handler    http.Handler
+	}{
+		{"metrics", val.metricsAddr, metricsMux},
+		{"RPC", val.rpcAddr, rpcMux},
+		{"public RPC", val.publicRPCAddr, publicRPCMux},
+	} {
+		if e.addr == "" {
+			continue
+		}
+		listener, err := net.Listen("tcp", e.addr)
+		if err != nil {
+			for _, opened := range endpoints {
+				opened.listener.Close()
+			}
+			return nil, err
+		}
+		endpoints = append(endpoints, &httpEndpoint{e.name, e.handler, listener})
+	}
+	return endpoints, nil
+}
+
+// serveHTTP serves e until ctx is done.
+func (val *Validator) serveHTTP(ctx context.Context, e *httpEndpoint) {
+	server := &http.Server{Handler: e.handler, ReadHeaderTimeout: HTTPReadHeaderTimeout}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	val.log.Info("Serving ", e.name, " on ", e.listener.Addr())
+	if err := server.Serve(e.listener); err != nil && err != http.ErrServerClosed {
+		val.log.Error("Error serving ", e.name, ": ", err)
+	}
+}
@@ -0,0 +1,196 @@
+package PairBFT
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Checks that run on every received packet before any pairing is computed:
+// a packet rate limit per source address, a cache of recently seen packets,
+// and structural checks on the decoded message. When too many sources are
+// tracked, the least recently used one is forgotten.
+type (
+	InboundFilter struct {
+		mutex       sync.Mutex
+		rate        float64
+		burst       float64
+		sources     map[string]*list.Element
+		sourceOrder *list.List
+
+		seen, prevSeen map[[sha256.Size]byte]bool
+		seenLimit      int
+	}
+
+	inboundCounters struct {
+		rateLimited uint64
+		duplicate   uint64
+		malformed   uint64
+		useless     uint64
+		queueFull   uint64
+	}
+
+	sourceBucket struct {
+		src    string
+		bucket *TokenBucket
+	}
+)
+
+const (
+	MaxFilterSources  = 4096
+	DupCacheSize      = 8192
+	SourcePacketRate  = 200 // packets per second
+	SourcePacketBurst = 400
+)
+
+func (f *InboundFilter) Init(rate float64, burst float64, seenLimit int) {
+	f.rate = rate
+	f.burst = burst
+	f.sources = make(map[string]*list.Element)
+	f.sourceOrder = list.New()
+	f.seen = make(map[[sha256.Size]byte]bool)
+	f.prevSeen = make(map[[sha256.Size]byte]bool)
+	f.seenLimit = seenLimit
+}
+
+func (f *InboundFilter) allowSource(src string, now time.Time) bool {
+	f.mutex.Lock()
+	e, ok := f.sources[src]
+	if ok {
+		f.sourceOrder.MoveToFront(e)
+	} else {
+		if len(f.sources) >= MaxFilterSources {
+			oldest := f.sourceOrder.Back()
+			f.sourceOrder.Remove(oldest)
+			delete(f.sources, oldest.Value.(*sourceBucket).src)
+		}
+		b := &TokenBucket{}
+		b.Init(f.rate, f.burst)
+		e = f.sourceOrder.PushFront(&sourceBucket{src, b})
+		f.sources[src] = e
+	}
+	b := e.Value.(*sourceBucket).bucket
+	f.mutex.Unlock()
+	return b.Take(1, 0, now)
+}
+
+// firstSeen reports whether data was not received recently. The cache holds
+// two generations of packet hashes, so its size stays bounded.
+func (f *InboundFilter) firstSeen(data []byte) bool {
+	h := sha256.Sum256(data)
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.seen[h] || f.prevSeen[h] {
+		return false
+	}
+	if len(f.seen) >= f.seenLimit {
+		f.prevSeen = f.seen
+		f.seen = make(map[[sha256.Size]byte]bool)
+	}
+	f.seen[h] = true
+	return true
+}
+
+// acceptPacket applies the per-source, duplicate and staleness checks to pkt.
+func (val *Validator) acceptPacket(pkt Packet) bool {
+	if !val.inboundFilter.allowSource(pkt.Addr, time.Now()) {
+		atomic.AddUint64(&val.inboundCounters.rateLimited, 1)
+		return false
+	}
+	if !val.inboundFilter.firstSeen(pkt.Data) {
+		atomic.AddUint64(&val.inboundCounters.duplicate, 1)
+		return false
+	}
+	if val.isStalePacket(pkt) {
+		atomic.AddUint64(&val.inboundCounters.useless, 1)
+		return false
+	}
+	return true
+}
+
+// isStalePacket tells from its header alone whether pkt is an AddrRecord no
+// newer than the one we know, or a SyncResponse we did not ask for.
+// Truncated packets are left to the handlers, which count them as malformed.
+func (val *Validator) isStalePacket(pkt Packet) bool {
+	data := pkt.Data
+	if len(data) == 0 {
+		return false
+	}
+	switch data[0] {
+	case MsgTypeAddrRecord:
+		if len(data) < LenMsgType+lenAddrIndex+lenAddrSeq {
+			return false
+		}
+		rec := &AddrRecord{
+			ValIndex: binary.LittleEndian.Uint32(data[LenMsgType:]),
+			Seq:      binary.LittleEndian.Uint64(data[LenMsgType+lenAddrIndex:]),
+		}
+		return !val.addrBook.IsNewer(rec)
+	case MsgTypeSyncResponse:
+		if len(data) < LenMsgType+LenBlockHeight {
+			return false
+		}
+		return !val.syncRequested(pkt.Peer, binary.LittleEndian.Uint64(data[LenMsgType:]))
+	}
+	return false
+}
+
+func (val *Validator) msgLen() int {
+	numVals := len(val.valAddrSet)
+	aggSigLen := lenCounter*numVals + int(val.bls.pairing.G1Length())
+	return LenMsgType + LenBlockHeight + LenHash + 2*aggSigLen
+}
+
+func isSubSet(sub *AggSig, super *AggSig) bool {
+	for i, c := range sub.counters {
+		if c != 0 && super.counters[i] == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// isObsolete tells, without verifying any signature, whether handling msg
+// could not change our state.
+func (val *Validator) isObsolete(msg *Msg) bool {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+
+	if val.blockHeight > msg.blockHeight {
+		return true
+	}
+	if val.blockHeight < msg.blockHeight || val.state == StateIdle {
+		return false
+	}
+
+	switch msg.msgType {
+	case MsgTypePrepare:
+		if val.state == StateFinal || val.state == StateCommitted {
+			return true
+		}
+		return val.state == StatePrepared && isSubSet(msg.PSig, val.aggSig)
+	case MsgTypeCommit:
+		if val.state == StateFinal {
+			return true
+		}
+		return val.state == StateCommitted && isSubSet(msg.CSig, val.aggSig)
+	case MsgTypeCommitPrepare:
+		if val.state == StateFinalPrepared {
+			return true
+		}
+		return val.state == StateCommitPrepared && isSubSet(msg.PSig, val.aggSig)
+	}
+	return false
+}
+
+// isMalformed checks the parts of msg that Verify would otherwise find out
+// only after computing pairings.
+func (val *Validator) isMalformed(msg *Msg) bool {
+	if msg.msgType != MsgTypePrepare && msg.msgType != MsgTypeCommit && msg.msgType != MsgTypeCommitPrepare {
+		return true
+	}
+	return !msg.hasQuorum()
+}
@@ -0,0 +1,121 @@
+package PairBFT
+
+import (
+	"encoding/binary"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestInboundFilter(t *testing.T) {
+	f := &InboundFilter{}
+	f.Init(10, 5, 4)
+	now := time.Now()
+
+	for i := 0; i < 5; i++ {
+		if !f.allowSource("a", now) {
+			t.Error("Packet within burst rejected.")
+		}
+	}
+	if f.allowSource("a", now) {
+		t.Error("Packet over burst accepted.")
+	}
+	if !f.allowSource("b", now) {
+		t.Error("Rate limit shared between sources.")
+	}
+	if !f.allowSource("a", now.Add(200*time.Millisecond)) {
+		t.Error("Rate limit did not recover.")
+	}
+
+	for i := 0; i < 6; i++ {
+		if !f.firstSeen([]byte{byte(i)}) {
+			t.Error("New packet reported as duplicate.")
+		}
+	}
+	if f.firstSeen([]byte{5}) || f.firstSeen([]byte{4}) {
+		t.Error("Duplicate packet not detected.")
+	}
+	if len(f.seen)+len(f.prevSeen) > 2*4 {
+		t.Error("Duplicate cache grows without bound.")
+	}
+
+	// A full source table forgets the least recently used source
+	f.Init(10, 1, 4)
+	for i := 0; i < MaxFilterSources; i++ {
+		f.allowSource(strconv.Itoa(i), now)
+	}
+	f.allowSource("0", now)
+	f.allowSource("new", now)
+	if len(f.sources) != MaxFilterSources {
+		t.Error("Source table grows without bound.")
+	}
+	if f.allowSource("0", now) {
+		t.Error("Recently used source forgotten.")
+	}
+	if !f.allowSource("1", now) {
+		t.Error("Least recently used source not forgotten.")
+	}
+}
+
+func TestStalePacketFilter(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+	}
+	val := vals[0]
+
+	rec := &AddrRecord{ValIndex: 1, Seq: val.addrBook.Record(1).Seq, Addrs: []string{"10.0.0.1:3000"}}
+	rec.Sign(vals[1].bls, vals[1].privKey)
+	if !val.isStalePacket(Packet{Peer: 1, Data: rec.Bytes()}) {
+		t.Error("AddrRecord without a newer sequence number not filtered.")
+	}
+	rec.Seq++
+	rec.Sign(vals[1].bls, vals[1].privKey)
+	if val.isStalePacket(Packet{Peer: 1, Data: rec.Bytes()}) {
+		t.Error("Newer AddrRecord filtered.")
+	}
+
+	resp := make([]byte, lenSyncRespHeader)
+	resp[0] = MsgTypeSyncResponse
+	binary.LittleEndian.PutUint64(resp[LenMsgType:], 3)
+	if !val.isStalePacket(Packet{Peer: 1, Data: resp}) {
+		t.Error("Unrequested SyncResponse not filtered.")
+	}
+	val.requestSync(1)
+	if val.isStalePacket(Packet{Peer: 1, Data: resp}) {
+		t.Error("Requested SyncResponse filtered.")
+	}
+	if !val.isStalePacket(Packet{Peer: 2, Data: resp}) {
+		t.Error("SyncResponse from another peer not filtered.")
+	}
+}
+
+func TestObsoleteMessageFilter(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, time.Millisecond, false)
+	proposerID := getProposerID(1, numVals)
+	vals[proposerID].proposeBlock(1)
+
+	rcpt := (proposerID + 1) % numVals
+	data := vals[proposerID].genMsgData(rcpt)
+	msg := &Msg{}
+	msg.Init(vals[rcpt].bls, numVals, MsgTypeUnknown)
+	msg.SetBytes(data)
+	if vals[rcpt].isObsolete(msg) || vals[rcpt].isMalformed(msg) {
+		t.Error("Proposal filtered out.")
+	}
+	vals[rcpt].handleMsgData(data)
+	if !vals[rcpt].isObsolete(msg) {
+		t.Error("Message without new signers not filtered.")
+	}
+
+	vals[rcpt].handleMsgData(data[:len(data)-1])
+	if vals[rcpt].inboundCounters.malformed != 1 {
+		t.Error("Truncated message not rejected.")
+	}
+}
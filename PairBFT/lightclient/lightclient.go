@@ -0,0 +1,235 @@
+// Package lightclient follows a PairBFT chain without running a validator.
+// Starting from a trusted genesis validator set, it downloads blocks with
+// their finality certificates from full validators and checks that each
+// block extends the previous one and was finalized by a quorum of the
+// validator set in force at its height.
+//
+// A validator that caught up through a sync does not store the blocks it
+// skipped, nor in CommitPrepare mode the data of the block it synced to, so
+// a source may lack some heights. The client then asks the other sources,
+// and fails for that height if none of them has it.
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Nik-U/pbc"
+	"github.com/yindavidyang/QChain/PairBFT"
+)
+
+type (
+	// Header is a verified block. The chain has no separate header, so it
+	// carries the block data, from which the hash is derived.
+	Header struct {
+		Height uint64
+		Hash   []byte
+		Data   []byte
+		Cert   *PairBFT.FinalityCertificate
+	}
+
+	// Block is what a Source returns, before verification.
+	Block struct {
+		Height uint64
+		Hash   []byte
+		Data   []byte
+		Cert   *PairBFT.FinalityCertificate
+	}
+
+	// Source serves finalized blocks, typically a full validator.
+	Source interface {
+		Block(ctx context.Context, height uint64) (*Block, error)
+	}
+
+	// ValSetChange returns the validator set that takes over after header,
+	// or nil if the set stays the same. How a block announces a change is up
+	// to the application.
+	ValSetChange func(header *Header) ([]*pbc.Element, error)
+
+	Client struct {
+		mutex        sync.Mutex
+		bls          *PairBFT.BLS
+		chainID      string
+		sources      []Source
+		nextSource   int
+		valSet       []*pbc.Element
+		valSetChange ValSetChange
+		headers      map[uint64]*Header
+		valSets      map[uint64][]*pbc.Element // by the first height they sign
+		latest       *Header
+	}
+)
+
+const (
+	// MaxHeaders is how many verified headers the client keeps; asking for
+	// an older one fails with ErrHeaderDropped.
+	MaxHeaders = 1024
+)
+
+var (
+	ErrNoSources     = errors.New("lightclient: no sources")
+	ErrInvalidBlock  = errors.New("lightclient: block does not match its certificate")
+	ErrBrokenChain   = errors.New("lightclient: block does not extend the previous one")
+	ErrWrongChain    = errors.New("lightclient: certificate is for another chain")
+	ErrHeightTooLow  = errors.New("lightclient: height must be at least 1")
+	ErrHeaderDropped = errors.New("lightclient: header no longer kept")
+)
+
+// Init starts the client from the genesis validator set, which signs block 1.
+func (c *Client) Init(bls *PairBFT.BLS, chainID string, genesis []*pbc.Element, sources []Source) {
+	c.bls = bls
+	c.chainID = chainID
+	c.sources = sources
+	c.nextSource = 0
+	c.valSet = genesis
+	c.headers = make(map[uint64]*Header)
+	c.valSets = map[uint64][]*pbc.Element{1: genesis}
+	c.latest = nil
+}
+
+// SetValSetChange sets how the client learns about validator set changes.
+// Without it the genesis set is trusted forever.
+func (c *Client) SetValSetChange(f ValSetChange) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.valSetChange = f
+}
+
+// Latest returns the height of the last verified header, 0 if none.
+func (c *Client) Latest() uint64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.latest == nil {
+		return 0
+	}
+	return c.latest.Height
+}
+
+// VerifiedHeader returns the header at height, verifying the blocks up to it
+// that were not verified yet. Blocks are verified in order, as each must
+// extend the previous one and may change the validator set. The client is
+// not locked while blocks are downloaded.
+func (c *Client) VerifiedHeader(ctx context.Context, height uint64) (*Header, error) {
+	if height == 0 {
+		return nil, ErrHeightTooLow
+	}
+	for {
+		c.mutex.Lock()
+		if header, ok := c.headers[height]; ok {
+			c.mutex.Unlock()
+			return header, nil
+		}
+		if c.latest != nil && height <= c.latest.Height {
+			c.mutex.Unlock()
+			return nil, ErrHeaderDropped
+		}
+		latest, valSet := c.latest, c.valSet
+		c.mutex.Unlock()
+
+		next := uint64(1)
+		var prevHash []byte
+		if latest != nil {
+			next = latest.Height + 1
+			prevHash = latest.Hash
+		}
+		header, err := c.fetch(ctx, next, prevHash, valSet)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mutex.Lock()
+		// Another call may have verified the block meanwhile
+		if c.latest == latest {
+			err = c.accept(header)
+		}
+		c.mutex.Unlock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// fetch downloads the block at height from the sources in turn, until one
+// serves a block that verifies against prevHash and valSet.
+func (c *Client) fetch(ctx context.Context, height uint64, prevHash []byte, valSet []*pbc.Element) (*Header, error) {
+	if len(c.sources) == 0 {
+		return nil, ErrNoSources
+	}
+	var lastErr error
+	for i := 0; i < len(c.sources); i++ {
+		c.mutex.Lock()
+		source := c.sources[c.nextSource]
+		c.mutex.Unlock()
+		block, err := source.Block(ctx, height)
+		if err == nil {
+			err = c.verify(block, height, prevHash, valSet)
+		}
+		if err == nil {
+			return &Header{Height: block.Height, Hash: block.Hash, Data: block.Data, Cert: block.Cert}, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		lastErr = err
+		c.mutex.Lock()
+		c.nextSource = (c.nextSource + 1) % len(c.sources)
+		c.mutex.Unlock()
+	}
+	return nil, fmt.Errorf("lightclient: block %d: %v", height, lastErr)
+}
+
+func (c *Client) verify(block *Block, height uint64, prevHash []byte, valSet []*pbc.Element) error {
+	if block.Height != height || block.Cert == nil {
+		return ErrInvalidBlock
+	}
+	if !bytes.Equal(PairBFT.BlockHash(block.Data, prevHash), block.Hash) {
+		return ErrBrokenChain
+	}
+	cert := block.Cert
+	if cert.Height != height || !bytes.Equal(cert.Hash, block.Hash) {
+		return ErrInvalidBlock
+	}
+	if cert.ChainID != c.chainID {
+		return ErrWrongChain
+	}
+	return cert.Verify(c.bls, valSet, c.chainID, height)
+}
+
+// accept records a verified header and applies the validator set change it
+// announces.
+func (c *Client) accept(header *Header) error {
+	if c.valSetChange != nil {
+		valSet, err := c.valSetChange(header)
+		if err != nil {
+			return err
+		}
+		if valSet != nil {
+			c.valSet = valSet
+			c.valSets[header.Height+1] = valSet
+		}
+	}
+	c.headers[header.Height] = header
+	if header.Height > MaxHeaders {
+		delete(c.headers, header.Height-MaxHeaders)
+	}
+	c.latest = header
+	return nil
+}
+
+// ValSet returns the validator set that signs the block at height, as far as
+// the client has verified the chain.
+func (c *Client) ValSet(height uint64) []*pbc.Element {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var valSet []*pbc.Element
+	from := uint64(0)
+	for h, set := range c.valSets {
+		if h <= height && h >= from {
+			valSet, from = set, h
+		}
+	}
+	return valSet
+}
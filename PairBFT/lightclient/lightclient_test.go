@@ -0,0 +1,177 @@
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Nik-U/pbc"
+	"github.com/sirupsen/logrus"
+	"github.com/yindavidyang/QChain/PairBFT"
+)
+
+type blockingSource struct {
+	release chan struct{}
+}
+
+func (s *blockingSource) Block(ctx context.Context, height uint64) (*Block, error) {
+	<-s.release
+	return nil, errors.New("unavailable")
+}
+
+type tamperedSource struct {
+	Source
+}
+
+func (s *tamperedSource) Block(ctx context.Context, height uint64) (*Block, error) {
+	block, err := s.Source.Block(ctx, height)
+	if err != nil {
+		return nil, err
+	}
+	block.Data = append([]byte("forged"), block.Data...)
+	return block, nil
+}
+
+// startChain runs numVals validators in memory until they have finalized
+// height blocks, and returns their validator set and RPC servers.
+func startChain(t *testing.T, bls *PairBFT.BLS, numVals int, height uint64) ([]*pbc.Element, []*httptest.Server) {
+	privKeys := make([]*pbc.Element, numVals)
+	pubKeys := make([]*pbc.Element, numVals)
+	pubKeySigs := make([]*pbc.Element, numVals)
+	addrs := make([]string, numVals)
+	for i := 0; i < numVals; i++ {
+		privKeys[i], pubKeys[i], pubKeySigs[i] = PairBFT.GenValidatorKeys(bls)
+		addrs[i] = "127.0.0.1:0"
+	}
+	network := &PairBFT.MemNetwork{}
+	network.Init()
+	logger := logrus.New()
+	logger.Out = ioutil.Discard
+
+	stores := make([]*PairBFT.MemBlockStore, numVals)
+	servers := make([]*httptest.Server, numVals)
+	for i := 0; i < numVals; i++ {
+		transport := &PairBFT.MemTransport{}
+		transport.Init(i, network)
+		stores[i] = &PairBFT.MemBlockStore{}
+		stores[i].Init()
+		val, err := PairBFT.NewValidator(PairBFT.Config{
+			ChainID:       "test",
+			ID:            i,
+			BLS:           bls,
+			PrivKey:       privKeys[i],
+			ValAddrs:      addrs,
+			ValPubKeys:    pubKeys,
+			ValPubKeySigs: pubKeySigs,
+			EpochLen:      20 * time.Millisecond,
+			BranchFactor:  2,
+			Transport:     transport,
+			Store:         stores[i],
+			Logger:        logger,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Only the proposer of block 1 succeeds
+		if err := val.ProposeFirstBlock(); err != nil && err != PairBFT.ErrNotFirstProposer {
+			t.Fatal(err)
+		}
+		if err := val.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { val.Stop() })
+		servers[i] = httptest.NewServer(val.PublicRPCHandler())
+		t.Cleanup(servers[i].Close)
+	}
+
+	deadline := time.Now().Add(20 * time.Second)
+	for _, store := range stores {
+		for store.Height() < height {
+			if time.Now().After(deadline) {
+				t.Fatal("Chain did not reach height", height)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+	return pubKeys, servers
+}
+
+func TestLightClient(t *testing.T) {
+	bls := &PairBFT.BLS{}
+	bls.Init()
+	pubKeys, servers := startChain(t, bls, 4, 5)
+
+	good := &RPCSource{URL: servers[1].URL, BLS: bls}
+	bad := &tamperedSource{&RPCSource{URL: servers[0].URL, BLS: bls}}
+	client := &Client{}
+	client.Init(bls, "test", pubKeys, []Source{bad, good})
+
+	ctx := context.Background()
+	header, err := client.VerifiedHeader(ctx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if header.Height != 5 || client.Latest() != 5 {
+		t.Error("Wrong header:", header.Height, client.Latest())
+	}
+	prev, err := client.VerifiedHeader(ctx, 4)
+	if err != nil || !bytes.Equal(PairBFT.BlockHash(header.Data, prev.Hash), header.Hash) {
+		t.Error("Headers do not chain:", err)
+	}
+
+	// A client that trusts another validator set rejects the chain
+	_, otherKey := bls.GenKey()
+	other := &Client{}
+	other.Init(bls, "test", []*pbc.Element{pubKeys[0], pubKeys[1], pubKeys[2], otherKey}, []Source{good})
+	if _, err := other.VerifiedHeader(ctx, 1); err == nil {
+		t.Error("Accepted blocks of an untrusted validator set")
+	}
+
+	// So does one told that the set changed after block 2
+	changed := &Client{}
+	changed.Init(bls, "test", pubKeys, []Source{good})
+	changed.SetValSetChange(func(header *Header) ([]*pbc.Element, error) {
+		if header.Height == 2 {
+			return []*pbc.Element{otherKey, pubKeys[1], pubKeys[2], pubKeys[3]}, nil
+		}
+		return nil, nil
+	})
+	if _, err := changed.VerifiedHeader(ctx, 2); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := changed.VerifiedHeader(ctx, 3); err == nil {
+		t.Error("Accepted a block signed by the old validator set")
+	}
+	if set := changed.ValSet(3); set[0] != otherKey {
+		t.Error("Validator set change not recorded")
+	}
+}
+
+func TestLightClientUnlockedFetch(t *testing.T) {
+	bls := &PairBFT.BLS{}
+	bls.Init()
+	source := &blockingSource{make(chan struct{})}
+	client := &Client{}
+	client.Init(bls, "test", nil, []Source{source})
+
+	fetched := make(chan error)
+	go func() {
+		_, err := client.VerifiedHeader(context.Background(), 1)
+		fetched <- err
+	}()
+	latest := make(chan uint64)
+	go func() { latest <- client.Latest() }()
+	select {
+	case <-latest:
+	case <-time.After(time.Second):
+		t.Error("Client locked during a download")
+	}
+	close(source.release)
+	if err := <-fetched; err == nil {
+		t.Error("Failed download reported as verified")
+	}
+}
@@ -0,0 +1,82 @@
+package lightclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/yindavidyang/QChain/PairBFT"
+)
+
+// RPCSource downloads blocks from the JSON-RPC API of a validator, see
+// PairBFT.Validator.PublicRPCHandler and PairBFT.Config.PublicRPCAddr.
+type (
+	RPCSource struct {
+		URL    string
+		Client *http.Client // http.DefaultClient if nil
+		BLS    *PairBFT.BLS
+	}
+
+	rpcBlock struct {
+		Height      uint64          `json:"height"`
+		Hash        string          `json:"hash"`
+		Data        string          `json:"data"`
+		Certificate json.RawMessage `json:"certificate"`
+	}
+)
+
+func (s *RPCSource) Block(ctx context.Context, height uint64) (*Block, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      1,
+		"method":  "block",
+		"params":  map[string]uint64{"height": height},
+	})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r struct {
+		Result *rpcBlock         `json:"result"`
+		Error  *PairBFT.RPCError `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return nil, err
+	}
+	if r.Error != nil {
+		return nil, fmt.Errorf("%s: %s", s.URL, r.Error.Message)
+	}
+	if r.Result == nil {
+		return nil, ErrInvalidBlock
+	}
+
+	block := &Block{Height: r.Result.Height, Cert: &PairBFT.FinalityCertificate{}}
+	if block.Hash, err = hex.DecodeString(r.Result.Hash); err != nil {
+		return nil, err
+	}
+	if block.Data, err = hex.DecodeString(r.Result.Data); err != nil {
+		return nil, err
+	}
+	if err := block.Cert.SetJSON(s.BLS, r.Result.Certificate); err != nil {
+		return nil, err
+	}
+	return block, nil
+}
@@ -0,0 +1,84 @@
+package PairBFT
+
+import (
+	"strconv"
+	"sync"
+)
+
+// MemNetwork connects MemTransports within one process. Like UDP, delivery
+// is best effort: a message to a peer whose queue is full is dropped.
+type (
+	MemNetwork struct {
+		mutex     sync.RWMutex
+		endpoints map[int]*MemTransport
+	}
+
+	MemTransport struct {
+		id      int
+		network *MemNetwork
+		recv    chan Packet
+
+		closeMutex sync.RWMutex
+		closed     bool
+	}
+)
+
+func (n *MemNetwork) Init() {
+	n.endpoints = make(map[int]*MemTransport)
+}
+
+func (t *MemTransport) Init(id int, network *MemNetwork) {
+	t.id = id
+	t.network = network
+	t.recv = make(chan Packet, TransportQueueSize)
+	network.mutex.Lock()
+	network.endpoints[id] = t
+	network.mutex.Unlock()
+}
+
+func (t *MemTransport) Send(peer int, data []byte) error {
+	t.network.mutex.RLock()
+	dest, ok := t.network.endpoints[peer]
+	t.network.mutex.RUnlock()
+	if !ok {
+		return ErrUnknownPeer
+	}
+	b := make([]byte, len(data))
+	copy(b, data)
+	return dest.deliver(Packet{Peer: t.id, Addr: "mem:" + strconv.Itoa(t.id), Data: b})
+}
+
+func (t *MemTransport) deliver(pkt Packet) error {
+	t.closeMutex.RLock()
+	defer t.closeMutex.RUnlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	select {
+	case t.recv <- pkt:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (t *MemTransport) Receive() <-chan Packet {
+	return t.recv
+}
+
+func (t *MemTransport) Close() error {
+	t.network.mutex.Lock()
+	if t.network.endpoints[t.id] == t {
+		delete(t.network.endpoints, t.id)
+	}
+	t.network.mutex.Unlock()
+
+	t.closeMutex.Lock()
+	defer t.closeMutex.Unlock()
+	if t.closed {
+		return ErrTransportClosed
+	}
+	t.closed = true
+	close(t.recv)
+	return nil
+}
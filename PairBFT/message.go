@@ -13,11 +13,14 @@ type (
 		PSig, CSig     *AggSig
 
 		pPairer, cPairer *pbc.Pairer
+		view             uint64 // hashVersion of the validator the pairers were taken from
+		peer             int    // the peer the message was received from, -1 if unknown
 	}
 )
 
 func (msg *Msg) Init(bls *BLS, numVals int, msgType byte) {
 	msg.msgType = msgType
+	msg.peer = -1
 	msg.hash = make([]byte, LenHash)
 	msg.CSig = &AggSig{}
 	msg.CSig.Init(bls, numVals)
@@ -60,18 +63,34 @@ func (msg *Msg) SetBytes(b []byte) {
 	msg.PSig.SetBytes(b[i:])
 }
 
+// AggSig returns the aggregate of the phase the message is about
+func (msg *Msg) AggSig() *AggSig {
+	if msg.msgType == MsgTypeCommit {
+		return msg.CSig
+	}
+	return msg.PSig
+}
+
 func (msg *Msg) VerifyPSig(bls *BLS, pubKeys []*pbc.Element) bool {
-	numVals := len(pubKeys)
+	return msg.verifyPSig(bls, &pubKeyList{bls, pubKeys})
+}
+
+func (msg *Msg) verifyPSig(bls *BLS, keys AggKeySource) bool {
+	numVals := len(msg.PSig.counters)
 	proposerID := getProposerID(msg.blockHeight, numVals)
 	if msg.PSig.counters[proposerID] == 0 {
 		// Todo: slash all validators contained in the message
 		return false
 	}
-	return msg.PSig.VerifyPreprocessed(bls, msg.pPairer, pubKeys)
+	return msg.PSig.VerifyPreprocessedWith(bls, msg.pPairer, keys)
 }
 
 func (msg *Msg) VerifyCSig(bls *BLS, pubKeys []*pbc.Element) bool {
-	return msg.CSig.VerifyPreprocessed(bls, msg.cPairer, pubKeys)
+	return msg.verifyCSig(bls, &pubKeyList{bls, pubKeys})
+}
+
+func (msg *Msg) verifyCSig(bls *BLS, keys AggKeySource) bool {
+	return msg.CSig.VerifyPreprocessedWith(bls, msg.cPairer, keys)
 }
 
 func (msg *Msg) Preprocess(bls *BLS, useCommitPrepare bool) {
@@ -88,21 +107,42 @@ func (msg *Msg) Preprocess(bls *BLS, useCommitPrepare bool) {
 }
 
 func (msg *Msg) Verify(bls *BLS, pubKeys []*pbc.Element) bool {
-	if !msg.VerifyPSig(bls, pubKeys) {
+	return msg.VerifyWith(bls, &pubKeyList{bls, pubKeys})
+}
+
+// VerifyWith is Verify with the aggregated public keys taken from keys,
+// typically an AggKeyCache.
+func (msg *Msg) VerifyWith(bls *BLS, keys AggKeySource) bool {
+	if !msg.hasQuorum() || !msg.verifyPSig(bls, keys) {
 		return false
 	}
-
 	if msg.msgType == MsgTypeCommit || msg.blockHeight > 1 {
-		if !msg.VerifyCSig(bls, pubKeys) {
-			return false
-		}
+		return msg.verifyCSig(bls, keys)
 	}
+	return true
+}
 
+// hasQuorum runs the checks of Verify that need no pairing.
+func (msg *Msg) hasQuorum() bool {
+	numVals := len(msg.PSig.counters)
+	if msg.PSig.counters[getProposerID(msg.blockHeight, numVals)] == 0 {
+		// Todo: slash all validators contained in the message
+		return false
+	}
 	if msg.msgType == MsgTypeCommit {
 		return msg.PSig.ReachQuorum()
 	} else if msg.blockHeight > 1 {
 		return msg.CSig.ReachQuorum()
 	}
-
 	return true
 }
+
+// SigItems returns the signatures Verify checks, for BLS.BatchVerify. The
+// message is valid if all of them are and hasQuorum holds.
+func (msg *Msg) SigItems(keys AggKeySource) []BatchItem {
+	items := []BatchItem{{msg.pPairer, msg.PSig.sig, keys.AggKey(msg.PSig.counters)}}
+	if msg.msgType == MsgTypeCommit || msg.blockHeight > 1 {
+		items = append(items, BatchItem{msg.cPairer, msg.CSig.sig, keys.AggKey(msg.CSig.counters)})
+	}
+	return items
+}
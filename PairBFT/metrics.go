@@ -0,0 +1,195 @@
+package PairBFT
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A validator counts what it sends, receives and verifies, and how long its
+// blocks take to commit and finalize from when it first saw them.
+// WriteMetrics renders these, with the counters of the bandwidth limit and
+// the inbound filter, in the Prometheus text format; MetricsHandler serves
+// them over HTTP.
+type (
+	histogram struct {
+		mutex  sync.Mutex
+		bounds []float64
+		counts []uint64 // per bucket, the last one for +Inf
+		sum    float64
+		count  uint64
+	}
+
+	metrics struct {
+		msgsSent       [numMsgTypes]uint64
+		msgsReceived   [numMsgTypes]uint64
+		verifyFailures uint64
+		finalized      uint64
+
+		verifyTime   histogram
+		commitTime   histogram
+		finalizeTime histogram
+
+		// When the validator first saw its current and previous block, which
+		// is not when they were proposed; guarded by stateMutex
+		blockSeen, prevBlockSeen time.Time
+	}
+)
+
+var (
+	verifyBuckets  = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25}
+	latencyBuckets = []float64{.01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+)
+
+func (h *histogram) init(bounds []float64) {
+	h.bounds = bounds
+	h.counts = make([]uint64, len(bounds)+1)
+	h.sum = 0
+	h.count = 0
+}
+
+func (h *histogram) observe(v float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := 0
+	for i < len(h.bounds) && v > h.bounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer, name string, labels string) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	cumulative := uint64(0)
+	for i, bound := range h.bounds {
+		cumulative += h.counts[i]
+		fmt.Fprintf(w, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, bound, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)]
+	fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, cumulative)
+	fmt.Fprintf(w, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+func (m *metrics) init() {
+	m.verifyTime.init(verifyBuckets)
+	m.commitTime.init(latencyBuckets)
+	m.finalizeTime.init(latencyBuckets)
+}
+
+func countMsg(counters *[numMsgTypes]uint64, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	msgType := data[0]
+	if msgType >= numMsgTypes {
+		msgType = MsgTypeUnknown
+	}
+	atomic.AddUint64(&counters[msgType], 1)
+}
+
+// seeBlock records that the validator saw a new block.
+func (m *metrics) seeBlock() {
+	m.prevBlockSeen = m.blockSeen
+	m.blockSeen = time.Now()
+}
+
+func observeSince(h *histogram, start time.Time) {
+	if !start.IsZero() {
+		h.observe(time.Since(start).Seconds())
+	}
+}
+
+// WriteMetrics writes the metrics of the validator in the Prometheus text
+// format.
+func (val *Validator) WriteMetrics(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	m := &val.metrics
+	id := fmt.Sprintf("validator=\"%d\"", val.id)
+
+	perType := func(name string, help string, counters *[numMsgTypes]uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for t := byte(0); t < numMsgTypes; t++ {
+			fmt.Fprintf(bw, "%s{%s,type=\"%s\"} %d\n", name, id, MsgTypeName(t), atomic.LoadUint64(&counters[t]))
+		}
+	}
+	counter := func(name string, help string, v uint64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s counter\n%s{%s} %d\n", name, help, name, name, id, v)
+	}
+	gauge := func(name string, help string, v float64) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n%s{%s} %g\n", name, help, name, name, id, v)
+	}
+	hist := func(name string, help string, h *histogram) {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+		h.write(bw, name, id)
+	}
+
+	perType("pairbft_messages_sent_total", "Messages sent, by type.", &m.msgsSent)
+	perType("pairbft_messages_received_total", "Messages received past the inbound filter, by type.", &m.msgsReceived)
+	perType("pairbft_sent_bytes_total", "Bytes sent, by message type.", &val.sendCounters.bytesSent)
+	perType("pairbft_dropped_bytes_total", "Bytes dropped by the bandwidth limit, by message type.", &val.sendCounters.bytesDropped)
+
+	name := "pairbft_inbound_dropped_total"
+	fmt.Fprintf(bw, "# HELP %s Received packets dropped, by reason.\n# TYPE %s counter\n", name, name)
+	for _, reason := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"rate_limited", &val.inboundCounters.rateLimited},
+		{"duplicate", &val.inboundCounters.duplicate},
+		{"malformed", &val.inboundCounters.malformed},
+		{"useless", &val.inboundCounters.useless},
+		{"queue_full", &val.inboundCounters.queueFull},
+	} {
+		fmt.Fprintf(bw, "%s{%s,reason=\"%s\"} %d\n", name, id, reason.name, atomic.LoadUint64(reason.value))
+	}
+
+	counter("pairbft_verify_failures_total", "Messages that failed verification.", atomic.LoadUint64(&m.verifyFailures))
+	counter("pairbft_finalized_blocks_total", "Blocks finalized by consensus, not counting syncs.", atomic.LoadUint64(&m.finalized))
+	hist("pairbft_verify_seconds", "Time to verify a batch of signatures.", &m.verifyTime)
+	hist("pairbft_seen_to_commit_seconds", "Time from first seeing a block to committing it.", &m.commitTime)
+	hist("pairbft_seen_to_finalize_seconds", "Time from first seeing a block to finalizing it.", &m.finalizeTime)
+
+	val.stateMutex.Lock()
+	height, state := val.blockHeight, val.state
+	signers, numVals := 0, len(val.valAddrSet)
+	if val.aggSig != nil {
+		signers = val.aggSig.NumSigners()
+	}
+	val.stateMutex.Unlock()
+	gauge("pairbft_block_height", "Current block height.", float64(height))
+	name = "pairbft_state"
+	fmt.Fprintf(bw, "# HELP %s Current state, 1 for the state the validator is in.\n# TYPE %s gauge\n", name, name)
+	for s := StateIdle; s <= StateFinalPrepared; s++ {
+		v := 0
+		if s == state {
+			v = 1
+		}
+		fmt.Fprintf(bw, "%s{%s,state=\"%s\"} %d\n", name, id, StateName(s), v)
+	}
+	gauge("pairbft_aggregate_signers", "Distinct signers in the current aggregate.", float64(signers))
+	coverage := 0.0
+	if numVals > 0 {
+		coverage = float64(signers) / float64(numVals)
+	}
+	gauge("pairbft_aggregate_coverage", "Fraction of the validators in the current aggregate.", coverage)
+
+	return bw.Flush()
+}
+
+// MetricsHandler serves the metrics of the validator.
+func (val *Validator) MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		if err := val.WriteMetrics(w); err != nil {
+			val.log.Debug("Failed to write metrics: ", err)
+		}
+	})
+}
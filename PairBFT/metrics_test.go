@@ -0,0 +1,39 @@
+package PairBFT
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteMetrics(t *testing.T) {
+	val := &Validator{id: 2, blockHeight: 5, state: StateCommitted}
+	val.metrics.init()
+	countMsg(&val.metrics.msgsSent, []byte{MsgTypeCommit, 0})
+	countMsg(&val.metrics.msgsSent, []byte{MsgTypeCommit, 0})
+	countMsg(&val.metrics.msgsReceived, []byte{0xff})
+	val.metrics.verifyTime.observe(0.002)
+	val.metrics.verifyTime.observe(1)
+
+	var buf bytes.Buffer
+	if err := val.WriteMetrics(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, line := range []string{
+		`pairbft_messages_sent_total{validator="2",type="Commit"} 2`,
+		`pairbft_messages_received_total{validator="2",type="Unknown"} 1`,
+		`pairbft_verify_seconds_bucket{validator="2",le="0.001"} 0`,
+		`pairbft_verify_seconds_bucket{validator="2",le="0.0025"} 1`,
+		`pairbft_verify_seconds_bucket{validator="2",le="+Inf"} 2`,
+		`pairbft_verify_seconds_count{validator="2"} 2`,
+		`pairbft_block_height{validator="2"} 5`,
+		`pairbft_state{validator="2",state="Committed"} 1`,
+		`pairbft_state{validator="2",state="Final"} 0`,
+		"# TYPE pairbft_seen_to_finalize_seconds histogram",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Error("Missing line:", line)
+		}
+	}
+}
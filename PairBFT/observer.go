@@ -0,0 +1,55 @@
+package PairBFT
+
+// An observer runs the same state machine as a validator and verifies every
+// message the same way, but has no signing key and no place in the validator
+// set: its aggregates only ever hold the signatures of validators, so it
+// never counts toward a quorum and never proposes. Observers have peer
+// indices after the validators, see Config.ObserverAddrs. Validators push
+// their current message to a random observer on every send, and answer
+// their sync requests; an observer in turn sends what it holds to a random
+// validator, which in push-pull mode answers if it knows more.
+
+// IsObserver tells whether the validator runs as a non-voting observer.
+func (val *Validator) IsObserver() bool {
+	return val.observer
+}
+
+// numPeers counts the validators and the observers.
+func (val *Validator) numPeers() int {
+	return len(val.valAddrSet) + len(val.observerAddrs)
+}
+
+// sendToObserver pushes the current message to a random observer.
+func (val *Validator) sendToObserver() {
+	numVals := len(val.valAddrSet)
+	numObservers := len(val.observerAddrs)
+	if numObservers == 0 {
+		return
+	}
+	rcpt := numVals + secureRandIntn(numObservers)
+	if rcpt == val.id {
+		return
+	}
+	if data := val.genMsgData(rcpt); data != nil {
+		val.sendData(rcpt, data)
+	}
+}
+
+// observerSend is what an observer does on every send: it passes its
+// aggregate to a random validator, or asks for a sync if it has none yet.
+func (val *Validator) observerSend() {
+	numVals := len(val.valAddrSet)
+	rcpt := secureRandIntn(numVals)
+
+	val.stateMutex.Lock()
+	idle := val.state == StateIdle
+	hasSigners := val.aggSig != nil && val.aggSig.NumSigners() > 0
+	val.stateMutex.Unlock()
+	if idle {
+		val.requestSync(rcpt)
+	} else if hasSigners {
+		if data := val.genMsgData(rcpt); data != nil {
+			val.sendData(rcpt, data)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package PairBFT
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/Nik-U/pbc"
+	"github.com/sirupsen/logrus"
+)
+
+// An observer follows the chain the validators finalize without ever
+// signing.
+func TestObserver(t *testing.T) {
+	numVals := 4
+	bls := &BLS{}
+	bls.Init()
+	cfg := Config{
+		BLS:           bls,
+		ValAddrs:      genLocalValidatorAddresses(numVals),
+		ValPubKeys:    make([]*pbc.Element, numVals),
+		ValPubKeySigs: make([]*pbc.Element, numVals),
+		ObserverAddrs: []string{"127.0.0.1:2100"},
+		EpochLen:      20 * time.Millisecond,
+		BranchFactor:  2,
+		Logger:        logrus.New(),
+	}
+	cfg.Logger.SetOutput(ioutil.Discard)
+	privKeys := make([]*pbc.Element, numVals)
+	for i := 0; i < numVals; i++ {
+		privKeys[i], cfg.ValPubKeys[i], cfg.ValPubKeySigs[i] = GenValidatorKeys(bls)
+	}
+	valStore := &MemBlockStore{}
+	valStore.Init()
+	vals := make([]*Validator, numVals)
+	for i := 0; i < numVals; i++ {
+		c := cfg
+		c.ID = i
+		c.PrivKey = privKeys[i]
+		if i == 0 {
+			c.Store = valStore
+		}
+		var err error
+		if vals[i], err = NewValidator(c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := &MemBlockStore{}
+	store.Init()
+	cfg.ID = numVals
+	cfg.Observer = true
+	cfg.BranchFactor = 1
+	cfg.Store = store
+	observer, err := NewValidator(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if observer.SetAddrs([]string{"127.0.0.1:2101"}) != ErrObserverAddrs {
+		t.Error("Observer announced addresses.")
+	}
+
+	proposers := 0
+	for _, val := range vals {
+		if val.ProposeFirstBlock() == nil {
+			proposers++
+		}
+	}
+	if proposers != 1 {
+		t.Fatal("Expected 1 first proposer, got", proposers)
+	}
+	network := &MemNetwork{}
+	network.Init()
+	for i, val := range append(vals, observer) {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		val.SetTransport(tr)
+		if err := val.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer val.Stop()
+	}
+
+	deadline := time.Now().Add(20 * time.Second)
+	for store.Height() < 5 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if store.Height() < 5 {
+		t.Fatal("Observer did not follow the chain:", store.Height())
+	}
+	for h := uint64(1); h <= store.Height(); h++ {
+		block, err := store.Get(h)
+		if err == ErrBlockNotFound {
+			continue // skipped by a sync
+		}
+		if !block.AggSig.ReachQuorum() || len(block.AggSig.counters) != numVals {
+			t.Error("Block", h, "stored without a quorum of validators")
+		}
+		if err := observer.certificate(block).Verify(observer.bls, observer.valPubKeySet, observer.chainID, block.Height); err != nil {
+			t.Error("Block", h, ":", err)
+		}
+	}
+
+	for h := uint64(1); h <= 5; h++ {
+		block, err := store.Get(h)
+		if err != nil {
+			continue
+		}
+		valBlock, err := valStore.Get(h)
+		if err == nil && !bytes.Equal(block.Hash, valBlock.Hash) {
+			t.Error("Observer finalized another block", h)
+		}
+	}
+}
@@ -1,13 +1,19 @@
 package PairBFT
 
 import (
+	"flag"
 	"time"
 	"testing"
-	"sync"
+	"context"
 	"github.com/Nik-U/pbc"
 	"strconv"
+	"sync/atomic"
+	"io/ioutil"
+	"github.com/sirupsen/logrus"
 )
 
+var gossipModeFlag = flag.String("gossip", "push", "gossip mode of simulated validators: push or pushpull")
+
 func genLocalValidatorAddresses(numVals int) []string {
 	ret := make([]string, numVals)
 	address := "127.0.0.1"
@@ -18,32 +24,119 @@ func genLocalValidatorAddresses(numVals int) []string {
 	return ret
 }
 
-func genValidators(numVals int, bf int, epochLen time.Duration, useCommitPrepare bool) []Validator {
+func genValidators(numVals int, bf int, epochLen time.Duration, useCommitPrepare bool) []*Validator {
 	bls := &BLS{}
 	bls.Init()
 
-	validatorAddresses := genLocalValidatorAddresses(numVals)
-
-	vals := make([]Validator, numVals)
+	cfg := Config{
+		BLS:              bls,
+		ValAddrs:         genLocalValidatorAddresses(numVals),
+		ValPubKeys:       make([]*pbc.Element, numVals),
+		ValPubKeySigs:    make([]*pbc.Element, numVals),
+		EpochLen:         epochLen,
+		BranchFactor:     bf,
+		UseCommitPrepare: useCommitPrepare,
+	}
+	if *gossipModeFlag == "pushpull" {
+		cfg.GossipMode = GossipPushPull
+	}
+	privKeys := make([]*pbc.Element, numVals)
 	for i := 0; i < numVals; i++ {
-		vals[i].Init(i, bls, bf, epochLen, useCommitPrepare)
+		privKeys[i], cfg.ValPubKeys[i], cfg.ValPubKeySigs[i] = GenValidatorKeys(bls)
 	}
 
-	pubKeys := make([]*pbc.Element, numVals)
-	pubKeySigs := make([]*pbc.Element, numVals)
+	vals := make([]*Validator, numVals)
 	for i := 0; i < numVals; i++ {
-		pubKeys[i] = vals[i].PubKey
-		pubKeySigs[i] = vals[i].PubKeySig
+		cfg.ID = i
+		cfg.PrivKey = privKeys[i]
+		cfg.Logger = logrus.New()
+		cfg.Logger.SetOutput(ioutil.Discard)
+		var err error
+		if vals[i], err = NewValidator(cfg); err != nil {
+			panic(err)
+		}
 	}
+	return vals
+}
+
+type countingTransport struct {
+	Transport
+	bytesSent *int
+}
+
+func (t *countingTransport) Send(peer int, data []byte) error {
+	*t.bytesSent += len(data)
+	return t.Transport.Send(peer, data)
+}
+
+// memRoundsToFinality runs synchronous gossip rounds over in-memory
+// transports until every validator has finalized targetHeight, and returns
+// the number of rounds it took. Replies are delivered within the round they
+// are sent in.
+func memRoundsToFinality(vals []*Validator, targetHeight uint64, maxRounds int) int {
+	rounds, _ := roundsAndBytesToFinality(vals, targetHeight, maxRounds)
+	return rounds
+}
+
+func roundsAndBytesToFinality(vals []*Validator, targetHeight uint64, maxRounds int) (int, int) {
+	numVals := len(vals)
+	network := &MemNetwork{}
+	network.Init()
+	bytesSent := 0
 	for i := 0; i < numVals; i++ {
-		vals[i].SetValSet(validatorAddresses, pubKeys, pubKeySigs)
+		t := &MemTransport{}
+		t.Init(i, network)
+		vals[i].SetTransport(&countingTransport{t, &bytesSent})
 	}
-	return vals
+
+	vals[getProposerID(1, numVals)].proposeBlock(1)
+	for round := 1; round <= maxRounds; round++ {
+		for j := 0; j < numVals; j++ {
+			vals[j].Send()
+		}
+		for delivered := true; delivered; {
+			delivered = false
+			for j := 0; j < numVals; j++ {
+				for len(vals[j].transport.Receive()) > 0 {
+					pkt := <-vals[j].transport.Receive()
+					vals[j].handleMsgDataFrom(pkt.Peer, pkt.Data)
+					delivered = true
+				}
+			}
+		}
+
+		done := true
+		for j := 0; j < numVals; j++ {
+			if finalizedHeight(vals[j]) < targetHeight {
+				done = false
+			}
+		}
+		if done {
+			return round, bytesSent
+		}
+	}
+	return maxRounds, bytesSent
 }
 
 func SimulatePairBFT(numVals int, bf int, epoch time.Duration, numEpochs int, useCommitPrepare bool) {
 	vals := genValidators(numVals, bf, epoch, useCommitPrepare)
+	runValidators(vals, numEpochs, useCommitPrepare)
+}
+
+func SimulatePairBFTInMemory(numVals int, bf int, epoch time.Duration, numEpochs int, useCommitPrepare bool) {
+	vals := genValidators(numVals, bf, epoch, useCommitPrepare)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		t := &MemTransport{}
+		t.Init(i, network)
+		vals[i].SetTransport(t)
+	}
+	runValidators(vals, numEpochs, useCommitPrepare)
+}
 
+func runValidators(vals []*Validator, numEpochs int, useCommitPrepare bool) {
+	numVals := len(vals)
 	proposerID := getProposerID(1, numVals)
 
 	// the first block must be Block 1, not Block 0
@@ -53,17 +146,17 @@ func SimulatePairBFT(numVals int, bf int, epoch time.Duration, numEpochs int, us
 		vals[proposerID].proposeBlock(1)
 	}
 
-	var wg sync.WaitGroup
 	for i := 0; i < numVals; i++ {
 		vals[i].debugEpochLimit = numEpochs
-		val := vals[i]
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			val.Start()
-		}()
+		if err := vals[i].Start(context.Background()); err != nil {
+			panic(err)
+		}
+	}
+	for i := 0; i < numVals; i++ {
+		if err := vals[i].Wait(); err != nil {
+			panic(err)
+		}
 	}
-	wg.Wait()
 }
 
 func TestPairBFT_n4_bf1_e50(t *testing.T) {
@@ -82,6 +175,148 @@ func TestPairBFT_cp_n4_bf1_e50(t *testing.T) {
 	SimulatePairBFT(numVals, bf, epoch, numEpochs, true)
 }
 
+func TestPairBFT_mem_n10_bf2_e50(t *testing.T) {
+	numVals := 10
+	bf := 2
+	numEpochs := 20
+	epoch := time.Millisecond * 50
+	SimulatePairBFTInMemory(numVals, bf, epoch, numEpochs, false)
+}
+
+func TestPairBFT_mem_n10_workers4(t *testing.T) {
+	numVals := 10
+	vals := genValidators(numVals, 2, 50*time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+		vals[i].SetVerifyWorkers(4)
+	}
+	runValidators(vals, 20, false)
+	for i := 0; i < numVals; i++ {
+		if finalizedHeight(vals[i]) < 1 {
+			t.Error("Validator ", i, " finalized nothing.")
+		}
+	}
+}
+
+func TestValidatorStop(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, 10*time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+	}
+	vals[getProposerID(1, numVals)].proposeBlock(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	for i := 0; i < numVals; i++ {
+		if err := vals[i].Start(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := vals[0].Start(ctx); err != ErrValidatorStarted {
+		t.Error("Started twice:", err)
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	if err := vals[0].Stop(); err != nil {
+		t.Error("Stop failed:", err)
+	}
+	if err := vals[0].Start(ctx); err != ErrValidatorStarted {
+		t.Error("Restarted a stopped validator:", err)
+	}
+	cancel()
+	for i := 1; i < numVals; i++ {
+		if err := vals[i].Wait(); err != nil {
+			t.Error("Validator", i, "failed:", err)
+		}
+	}
+}
+
+func TestPushPullRounds(t *testing.T) {
+	numVals := 40
+	bf := 2
+	numHeights := uint64(5)
+	numRuns := 5
+	maxRounds := 1000
+
+	pushRounds, pushPullRounds := 0, 0
+	for run := 0; run < numRuns; run++ {
+		vals := genValidators(numVals, bf, time.Millisecond, false)
+		for i := 0; i < numVals; i++ {
+			vals[i].SetGossipMode(GossipPush)
+		}
+		pushRounds += memRoundsToFinality(vals, numHeights, maxRounds)
+
+		vals = genValidators(numVals, bf, time.Millisecond, false)
+		for i := 0; i < numVals; i++ {
+			vals[i].SetGossipMode(GossipPushPull)
+		}
+		pushPullRounds += memRoundsToFinality(vals, numHeights, maxRounds)
+	}
+
+	t.Log("Average rounds to finalize ", numHeights, " blocks, push: ", float64(pushRounds)/float64(numRuns),
+		", push-pull: ", float64(pushPullRounds)/float64(numRuns))
+	if pushPullRounds >= numRuns*maxRounds {
+		t.Error("Push-pull gossip did not reach finality.")
+	}
+}
+
+func TestPushPullReply(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+	}
+	proposer := getProposerID(1, numVals)
+	receiver := (proposer + 1) % numVals
+	other := (proposer + 2) % numVals
+	vals[receiver].SetGossipMode(GossipPushPull)
+	vals[proposer].proposeBlock(1)
+	data := vals[proposer].genMsgData(receiver)
+
+	replies := func(peer int) int {
+		n := 0
+		for len(vals[peer].transport.Receive()) > 0 {
+			<-vals[peer].transport.Receive()
+			n++
+		}
+		return n
+	}
+
+	// The receiver adds its signature, so it knows more than the proposer
+	vals[receiver].handleMsgDataFrom(proposer, data)
+	if n := replies(proposer); n != 1 {
+		t.Error("Proposer got", n, "replies instead of 1.")
+	}
+	vals[receiver].handleMsgDataFrom(proposer, data)
+	if n := replies(proposer); n != 0 {
+		t.Error("Proposer answered twice in one epoch.")
+	}
+
+	// A packet claiming to come from a validator that did not sign it is
+	// not answered
+	atomic.AddUint64(&vals[receiver].sendEpoch, 1)
+	vals[receiver].handleMsgDataFrom(other, data)
+	if n := replies(other); n != 0 {
+		t.Error("Answered a forged sender.")
+	}
+	vals[receiver].handleMsgDataFrom(proposer, data)
+	if n := replies(proposer); n != 1 {
+		t.Error("Proposer got", n, "replies in the next epoch instead of 1.")
+	}
+}
+
 func TestPairBFT_n10_bf2_e100(t *testing.T) {
 	numVals := 10
 	bf := 2
@@ -0,0 +1,166 @@
+package PairBFT
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+)
+
+type (
+	// GossipView is what a PeerSelector knows about the local validator when
+	// it picks a recipient.
+	GossipView struct {
+		Self        int
+		NumVals     int
+		BlockHeight uint64
+		MsgType     byte
+		Counters    []uint32
+	}
+
+	PeerSelector interface {
+		ChooseRcpt(view *GossipView) int
+		// Observe records the aggregate a peer has sent us.
+		Observe(peer int, blockHeight uint64, msgType byte, counters []uint32)
+		// Sent records the aggregate we have sent a peer.
+		Sent(peer int, blockHeight uint64, msgType byte, counters []uint32)
+	}
+
+	// RandomSelector picks a peer uniformly at random.
+	RandomSelector struct{}
+
+	// KnowledgeSelector prefers peers that are behind us or whose signatures
+	// are missing from our aggregate. It assumes that a peer knows whatever it
+	// has sent us and whatever we have sent it.
+	KnowledgeSelector struct {
+		mutex sync.Mutex
+		peers map[int]*peerKnowledge
+	}
+
+	peerKnowledge struct {
+		blockHeight uint64
+		msgType     byte
+		counters    []uint32
+	}
+)
+
+func secureRandIntn(n int) int {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	return int(binary.LittleEndian.Uint64(b[:]) % uint64(n))
+}
+
+func chooseRandomPeer(self int, numVals int) int {
+	rcpt := secureRandIntn(numVals - 1)
+	if rcpt >= self {
+		rcpt++
+	}
+	return rcpt
+}
+
+func (s *RandomSelector) ChooseRcpt(view *GossipView) int {
+	return chooseRandomPeer(view.Self, view.NumVals)
+}
+
+func (s *RandomSelector) Observe(peer int, blockHeight uint64, msgType byte, counters []uint32) {
+}
+
+func (s *RandomSelector) Sent(peer int, blockHeight uint64, msgType byte, counters []uint32) {
+}
+
+func (s *KnowledgeSelector) Init() {
+	s.peers = make(map[int]*peerKnowledge)
+}
+
+// Phases of one height in the order they are gossiped
+func phaseRank(msgType byte) int {
+	switch msgType {
+	case MsgTypeCommit:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func (s *KnowledgeSelector) score(view *GossipView, peer int) int {
+	numVals := view.NumVals
+	k, ok := s.peers[peer]
+	if !ok || k.blockHeight < view.BlockHeight ||
+		(k.blockHeight == view.BlockHeight && phaseRank(k.msgType) < phaseRank(view.MsgType)) {
+		// The peer has nothing we are missing yet, but everything we have is news to it
+		return numVals + 1
+	}
+	if k.blockHeight > view.BlockHeight || phaseRank(k.msgType) > phaseRank(view.MsgType) {
+		return 1
+	}
+
+	score := 1
+	for i := 0; i < numVals; i++ {
+		if view.Counters[i] != 0 && k.counters[i] == 0 {
+			score++ // we can tell the peer about signer i
+		}
+		if view.Counters[i] == 0 && k.counters[i] != 0 {
+			score++ // the peer can tell us about signer i
+		}
+	}
+	if view.Counters[peer] == 0 {
+		score += numVals / 2
+	}
+	return score
+}
+
+func (s *KnowledgeSelector) ChooseRcpt(view *GossipView) int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	// Sample with probability proportional to the score, so that ties and
+	// stale knowledge do not starve any peer
+	scores := make([]int, view.NumVals)
+	total := 0
+	for i := 0; i < view.NumVals; i++ {
+		if i != view.Self {
+			scores[i] = s.score(view, i)
+			total += scores[i]
+		}
+	}
+	rcpt := chooseRandomPeer(view.Self, view.NumVals)
+	if total > 0 {
+		r := secureRandIntn(total)
+		for i, score := range scores {
+			if r < score {
+				rcpt = i
+				break
+			}
+			r -= score
+		}
+	}
+	return rcpt
+}
+
+func (s *KnowledgeSelector) Observe(peer int, blockHeight uint64, msgType byte, counters []uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.merge(peer, blockHeight, msgType, counters)
+}
+
+func (s *KnowledgeSelector) Sent(peer int, blockHeight uint64, msgType byte, counters []uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.merge(peer, blockHeight, msgType, counters)
+}
+
+func (s *KnowledgeSelector) merge(peer int, blockHeight uint64, msgType byte, counters []uint32) {
+	k, ok := s.peers[peer]
+	if !ok || k.blockHeight < blockHeight || (k.blockHeight == blockHeight && phaseRank(k.msgType) < phaseRank(msgType)) {
+		k = &peerKnowledge{blockHeight, msgType, make([]uint32, len(counters))}
+		s.peers[peer] = k
+	} else if k.blockHeight > blockHeight || phaseRank(k.msgType) > phaseRank(msgType) || len(k.counters) != len(counters) {
+		return
+	}
+	for i, c := range counters {
+		if c != 0 {
+			k.counters[i] = 1
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package PairBFT
+
+import (
+	"testing"
+	"time"
+)
+
+func finalizedHeight(val *Validator) uint64 {
+	if val.state == StateFinal || val.blockHeight == 0 {
+		return val.blockHeight
+	}
+	return val.blockHeight - 1
+}
+
+func TestKnowledgeSelectorRounds(t *testing.T) {
+	numVals := 10
+	bf := 2
+	numHeights := uint64(5)
+	numRuns := 100
+	maxRounds := 1000
+
+	// Both run in push mode. The knowledge-aware selector takes about 3% fewer
+	// rounds, with a spread of about 2 rounds per run; over numRuns runs it
+	// must save at least 1%.
+	randomRounds, knowledgeRounds := 0, 0
+	for run := 0; run < numRuns; run++ {
+		vals := genValidators(numVals, bf, time.Millisecond, false)
+		for i := 0; i < numVals; i++ {
+			vals[i].SetGossipMode(GossipPush)
+		}
+		rounds, _ := roundsAndBytesToFinality(vals, numHeights, maxRounds)
+		randomRounds += rounds
+
+		vals = genValidators(numVals, bf, time.Millisecond, false)
+		for i := 0; i < numVals; i++ {
+			vals[i].SetGossipMode(GossipPush)
+			selector := &KnowledgeSelector{}
+			selector.Init()
+			vals[i].SetPeerSelector(selector)
+		}
+		rounds, _ = roundsAndBytesToFinality(vals, numHeights, maxRounds)
+		knowledgeRounds += rounds
+	}
+
+	t.Log("Average rounds to finalize ", numHeights, " blocks, random: ", float64(randomRounds)/float64(numRuns),
+		", knowledge-aware: ", float64(knowledgeRounds)/float64(numRuns))
+	if 100*knowledgeRounds > 99*randomRounds {
+		t.Error("Knowledge-aware gossip not faster than random gossip.")
+	}
+}
+
+func TestKnowledgeSelectorChoice(t *testing.T) {
+	numVals := 10
+	full := make([]uint32, numVals)
+	for i := range full {
+		full[i] = 1
+	}
+	view := &GossipView{Self: 0, NumVals: numVals, BlockHeight: 5, MsgType: MsgTypePrepare, Counters: full}
+
+	// Peer 9 has not been heard from, the others have sent everything we have
+	numTrials := 1000
+	picks := make([]int, numVals)
+	for trial := 0; trial < numTrials; trial++ {
+		selector := &KnowledgeSelector{}
+		selector.Init()
+		for peer := 1; peer < numVals-1; peer++ {
+			selector.Observe(peer, 5, MsgTypePrepare, full)
+		}
+		picks[selector.ChooseRcpt(view)]++
+	}
+	if picks[0] != 0 {
+		t.Error("Selector chose itself.")
+	}
+	// A uniform choice would pick peer 9 about numTrials/9 times, the selector
+	// with probability 11/19
+	if picks[numVals-1] < numTrials/3 {
+		t.Error("Peer without our aggregate not preferred: ", picks)
+	}
+
+	// A peer whose signature we lack is preferred too, with probability 7/23
+	view.Counters = append([]uint32(nil), full...)
+	view.Counters[4] = 0
+	picks = make([]int, numVals)
+	for trial := 0; trial < numTrials; trial++ {
+		selector := &KnowledgeSelector{}
+		selector.Init()
+		for peer := 1; peer < numVals; peer++ {
+			selector.Observe(peer, 5, MsgTypePrepare, full)
+		}
+		picks[selector.ChooseRcpt(view)]++
+	}
+	if picks[4] < numTrials/5 {
+		t.Error("Peer with a missing signature not preferred: ", picks)
+	}
+}
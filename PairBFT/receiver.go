@@ -0,0 +1,154 @@
+package PairBFT
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// Received packets go through three stages: the listener only reads the
+// transport and filters packets, a pool of workers decodes and verifies them
+// without holding the state lock, and a single goroutine applies the verified
+// messages to the validator state in the order they were verified. Sync
+// certificates take the same path: the workers verify them and the applying
+// goroutine moves the validator to their block.
+type (
+	inboundMsg struct {
+		peer     int
+		data     []byte
+		msg      *Msg
+		verified bool
+		cert     *syncCert // set for a verified SyncResponse
+	}
+)
+
+const (
+	RecvQueueSize  = 1024
+	MaxVerifyBatch = 32
+)
+
+// SetVerifyWorkers sets the number of goroutines verifying signatures; by
+// default there is one per CPU.
+func (val *Validator) SetVerifyWorkers(n int) {
+	val.numWorkers = n
+}
+
+// Listen handles received messages until ctx is done. It fails if the
+// transport is closed under it.
+func (val *Validator) Listen(ctx context.Context) error {
+	numWorkers := val.numWorkers
+	if numWorkers <= 0 {
+		numWorkers = runtime.NumCPU()
+	}
+	recvQueue := make(chan *inboundMsg, RecvQueueSize)
+	applyQueue := make(chan *inboundMsg, RecvQueueSize)
+
+	var workers sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			val.verifyLoop(recvQueue, applyQueue)
+		}()
+	}
+	applied := make(chan bool)
+	go func() {
+		val.applyLoop(applyQueue)
+		close(applied)
+	}()
+	defer func() {
+		close(recvQueue)
+		workers.Wait()
+		close(applyQueue)
+		<-applied
+	}()
+
+	for {
+		select {
+		case pkt, ok := <-val.transport.Receive():
+			if !ok {
+				return ErrTransportClosed
+			}
+			if !val.acceptPacket(pkt) {
+				continue
+			}
+			countMsg(&val.metrics.msgsReceived, pkt.Data)
+			select {
+			case recvQueue <- &inboundMsg{peer: pkt.Peer, data: pkt.Data}:
+			default:
+				atomic.AddUint64(&val.inboundCounters.queueFull, 1)
+			}
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// verifyLoop takes the messages that queued up behind the first one too, up
+// to MaxVerifyBatch, and verifies their signatures in one batch.
+func (val *Validator) verifyLoop(recvQueue <-chan *inboundMsg, applyQueue chan<- *inboundMsg) {
+	batch := make([]*inboundMsg, 0, MaxVerifyBatch)
+	for in := range recvQueue {
+		batch = append(batch[:0], in)
+		for more := true; more && len(batch) < MaxVerifyBatch; {
+			select {
+			case in, ok := <-recvQueue:
+				if ok {
+					batch = append(batch, in)
+				} else {
+					more = false
+				}
+			default:
+				more = false
+			}
+		}
+
+		var useful []*inboundMsg
+		var msgs []*Msg
+		decoded := batch[:0]
+		for _, in := range batch {
+			if len(in.data) > 0 && in.data[0] == MsgTypeSyncResponse {
+				if in.cert = val.verifySyncResponse(in.peer, in.data); in.cert != nil {
+					decoded = append(decoded, in)
+				}
+				continue
+			}
+			msg, ok := val.decodeMsg(in.peer, in.data)
+			if msg == nil {
+				continue
+			}
+			in.msg = msg
+			decoded = append(decoded, in)
+			if ok {
+				useful = append(useful, in)
+				msgs = append(msgs, msg)
+			}
+		}
+		if len(msgs) > 0 {
+			for i, verified := range val.verifyMsgs(msgs) {
+				useful[i].verified = verified
+			}
+		}
+		for _, in := range decoded {
+			applyQueue <- in
+		}
+	}
+}
+
+func (val *Validator) applyLoop(applyQueue <-chan *inboundMsg) {
+	for in := range applyQueue {
+		if in.cert != nil {
+			val.applySyncCert(in.cert)
+			continue
+		}
+		mark := val.getProgressMark()
+		verified := in.verified
+		if verified && !val.applyMsg(in.msg) {
+			// Our hashes changed after the message was verified; this is rare
+			// enough to verify it again right here
+			verified = val.handleMsg(in.msg)
+		}
+		val.afterMsg(in.peer, in.msg, verified, mark)
+	}
+}
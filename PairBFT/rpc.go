@@ -0,0 +1,316 @@
+package PairBFT
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// The RPC server speaks JSON-RPC 2.0 over HTTP POST, one call per request.
+// Besides the queries it has admin calls that change the validator at
+// runtime, so Config.Validate only lets it listen on a loopback address;
+// Config.PublicRPCAddr serves the queries alone, for light clients.
+type (
+	rpcRequest struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Method  string          `json:"method"`
+		Params  json.RawMessage `json:"params"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	rpcResponse struct {
+		JSONRPC string          `json:"jsonrpc"`
+		Result  interface{}     `json:"result,omitempty"`
+		Error   *RPCError       `json:"error,omitempty"`
+		ID      json.RawMessage `json:"id"`
+	}
+
+	RPCError struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+
+	StatusInfo struct {
+		ID         int      `json:"id"`
+		Height     uint64   `json:"height"`
+		State      string   `json:"state"`
+		Hash       string   `json:"hash"`
+		Counters   []uint32 `json:"counters"`
+		Signers    int      `json:"signers"`
+		PeerHeight uint64   `json:"peerHeight"`
+		Observer   bool     `json:"observer,omitempty"`
+	}
+
+	ValidatorInfo struct {
+		Index   int    `json:"index"`
+		Addr    string `json:"addr"`
+		PubKey  string `json:"pubKey"`
+		NodeKey string `json:"nodeKey,omitempty"`
+	}
+
+	BlockInfo struct {
+		Height      uint64               `json:"height"`
+		Hash        string               `json:"hash"`
+		Data        string               `json:"data"`
+		Counters    []uint32             `json:"counters"`
+		Certificate *FinalityCertificate `json:"certificate"`
+	}
+
+	GossipParamsInfo struct {
+		BranchFactor int    `json:"branchFactor"`
+		EpochLen     string `json:"epochLen"`
+		Mode         string `json:"mode"`
+	}
+)
+
+const (
+	RPCParseError     = -32700
+	RPCInvalidRequest = -32600
+	RPCMethodNotFound = -32601
+	RPCInvalidParams  = -32602
+	RPCInternalError  = -32603
+	RPCNotFound       = -32000
+)
+
+var gossipModeNames = map[int]string{
+	GossipPush:     "push",
+	GossipPushPull: "pushpull",
+}
+
+var adminMethods = map[string]bool{
+	"setLogLevel":     true,
+	"setGossipParams": true,
+}
+
+func rpcError(code int, message string) *RPCError {
+	return &RPCError{Code: code, Message: message}
+}
+
+// RPCHandler serves the JSON-RPC API of the validator. The methods are
+// status, validators, block {height}, certificate {height, binary} with the
+// binary encoding in hex if binary is set, gossipParams, and the admin calls
+// setLogLevel {level} and setGossipParams {branchFactor, epochLen, mode},
+// whose omitted fields are left unchanged. Requests must be sent as
+// application/json, and name a loopback host, so that web pages cannot reach
+// the admin calls through the browser of the validator's operator.
+func (val *Validator) RPCHandler() http.Handler {
+	return val.rpcHandler(true)
+}
+
+// PublicRPCHandler serves the methods of RPCHandler but the admin calls. It
+// accepts any host.
+func (val *Validator) PublicRPCHandler() http.Handler {
+	return val.rpcHandler(false)
+}
+
+func (val *Validator) rpcHandler(admin bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "JSON-RPC requests must be POSTed", http.StatusMethodNotAllowed)
+			return
+		}
+		if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+			http.Error(w, "JSON-RPC requests must be sent as application/json", http.StatusUnsupportedMediaType)
+			return
+		}
+		if admin && !isLoopbackHost(r.Host) {
+			http.Error(w, "JSON-RPC requests must name a loopback host", http.StatusForbidden)
+			return
+		}
+		resp := rpcResponse{JSONRPC: "2.0"}
+		var req rpcRequest
+		body := http.MaxBytesReader(w, r.Body, MaxRPCBodySize)
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			resp.Error = rpcError(RPCParseError, err.Error())
+		} else if req.JSONRPC != "2.0" || req.Method == "" {
+			resp.ID = req.ID
+			resp.Error = rpcError(RPCInvalidRequest, "not a JSON-RPC 2.0 request")
+		} else {
+			resp.ID = req.ID
+			if result, err := val.callRPC(req.Method, req.Params, admin); err != nil {
+				resp.Error = err
+			} else {
+				resp.Result = result
+			}
+		}
+		if resp.ID == nil {
+			resp.ID = json.RawMessage("null")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(&resp); err != nil {
+			val.log.Debug("Failed to write RPC response: ", err)
+		}
+	})
+}
+
+func (val *Validator) callRPC(method string, params json.RawMessage, admin bool) (interface{}, *RPCError) {
+	if adminMethods[method] && !admin {
+		return nil, rpcError(RPCMethodNotFound, "unknown method "+method)
+	}
+	switch method {
+	case "status":
+		return val.Status(), nil
+	case "validators":
+		return val.Validators(), nil
+	case "block":
+		var p struct {
+			Height uint64 `json:"height"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		return val.rpcBlock(p.Height)
+	case "certificate":
+		var p struct {
+			Height uint64 `json:"height"`
+			Binary bool   `json:"binary"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		block, rpcErr := val.rpcBlock(p.Height)
+		if rpcErr != nil {
+			return nil, rpcErr
+		}
+		if p.Binary {
+			return hex.EncodeToString(block.Certificate.Bytes()), nil
+		}
+		return block.Certificate, nil
+	case "gossipParams":
+		return gossipParamsInfo(val.GossipParams()), nil
+	case "setLogLevel":
+		var p struct {
+			Level string `json:"level"`
+		}
+		if err := decodeParams(params, &p); err != nil {
+			return nil, err
+		}
+		level, err := logrus.ParseLevel(p.Level)
+		if err != nil {
+			return nil, rpcError(RPCInvalidParams, err.Error())
+		}
+		val.SetLogLevel(level)
+		val.log.Info("Log level set to ", level)
+		return level.String(), nil
+	case "setGossipParams":
+		return val.rpcSetGossipParams(params)
+	}
+	return nil, rpcError(RPCMethodNotFound, "unknown method "+method)
+}
+
+func decodeParams(params json.RawMessage, v interface{}) *RPCError {
+	if len(params) == 0 {
+		return rpcError(RPCInvalidParams, "missing params")
+	}
+	if err := json.Unmarshal(params, v); err != nil {
+		return rpcError(RPCInvalidParams, err.Error())
+	}
+	return nil
+}
+
+func (val *Validator) Status() StatusInfo {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+	status := StatusInfo{
+		ID:         val.id,
+		Height:     val.blockHeight,
+		State:      StateName(val.state),
+		Hash:       hex.EncodeToString(val.hash),
+		PeerHeight: val.peerHeight,
+		Observer:   val.observer,
+	}
+	if val.aggSig != nil {
+		status.Counters = append([]uint32(nil), val.aggSig.counters...)
+		status.Signers = val.aggSig.NumSigners()
+	}
+	return status
+}
+
+func (val *Validator) Validators() []ValidatorInfo {
+	infos := make([]ValidatorInfo, len(val.valAddrSet))
+	for i := range infos {
+		infos[i] = ValidatorInfo{
+			Index:  i,
+			Addr:   val.addrBook.Addr(i),
+			PubKey: hex.EncodeToString(val.valPubKeySet[i].Bytes()),
+		}
+		if val.valNodeKeySet != nil {
+			infos[i].NodeKey = hex.EncodeToString(val.valNodeKeySet[i])
+		}
+	}
+	return infos
+}
+
+func (val *Validator) rpcBlock(height uint64) (*BlockInfo, *RPCError) {
+	if val.store == nil {
+		return nil, rpcError(RPCNotFound, "no block store")
+	}
+	block, err := val.store.Get(height)
+	if err == ErrBlockNotFound {
+		return nil, rpcError(RPCNotFound, err.Error())
+	} else if err != nil {
+		return nil, rpcError(RPCInternalError, err.Error())
+	}
+	return val.blockInfo(block), nil
+}
+
+func (val *Validator) blockInfo(block *FinalizedBlock) *BlockInfo {
+	return &BlockInfo{
+		Height:      block.Height,
+		Hash:        hex.EncodeToString(block.Hash),
+		Data:        hex.EncodeToString(block.Data),
+		Counters:    block.AggSig.counters,
+		Certificate: val.certificate(block),
+	}
+}
+
+func gossipParamsInfo(params GossipParams) *GossipParamsInfo {
+	return &GossipParamsInfo{
+		BranchFactor: params.BranchFactor,
+		EpochLen:     params.EpochLen.String(),
+		Mode:         gossipModeNames[params.Mode],
+	}
+}
+
+func (val *Validator) rpcSetGossipParams(raw json.RawMessage) (interface{}, *RPCError) {
+	var p struct {
+		BranchFactor *int    `json:"branchFactor"`
+		EpochLen     *string `json:"epochLen"`
+		Mode         *string `json:"mode"`
+	}
+	if err := decodeParams(raw, &p); err != nil {
+		return nil, err
+	}
+	params := val.GossipParams()
+	if p.BranchFactor != nil {
+		params.BranchFactor = *p.BranchFactor
+	}
+	if p.EpochLen != nil {
+		epochLen, err := time.ParseDuration(*p.EpochLen)
+		if err != nil {
+			return nil, rpcError(RPCInvalidParams, err.Error())
+		}
+		params.EpochLen = epochLen
+	}
+	if p.Mode != nil {
+		params.Mode = -1
+		for mode, name := range gossipModeNames {
+			if name == *p.Mode {
+				params.Mode = mode
+			}
+		}
+	}
+	if err := val.SetGossipParams(params); err != nil {
+		return nil, rpcError(RPCInvalidParams, err.Error())
+	}
+	val.log.WithFields(logrus.Fields{
+		"branchFactor": params.BranchFactor,
+		"epochLen":     params.EpochLen,
+		"mode":         gossipModeNames[params.Mode],
+	}).Info("Gossip parameters set")
+	return gossipParamsInfo(params), nil
+}
@@ -0,0 +1,145 @@
+package PairBFT
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func callTestRPC(t *testing.T, url string, method string, params string) (json.RawMessage, *RPCError) {
+	body := `{"jsonrpc":"2.0","id":1,"method":"` + method + `"`
+	if params != "" {
+		body += `,"params":` + params
+	}
+	body += "}"
+	resp, err := http.Post(url, "application/json", bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Result json.RawMessage `json:"result"`
+		Error  *RPCError       `json:"error"`
+		ID     int             `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatal(err)
+	}
+	if r.ID != 1 {
+		t.Error("Wrong id:", r.ID)
+	}
+	return r.Result, r.Error
+}
+
+func TestRPC(t *testing.T) {
+	val := &Validator{id: 1, log: logrus.New(), blockHeight: 4, state: StateFinal, hash: []byte{1, 2}}
+	val.SetLogOutput(&bytes.Buffer{})
+	val.branchFactor = 2
+	val.epochLen = 50 * time.Millisecond
+	store := &MemBlockStore{}
+	store.Init()
+	val.store = store
+	server := httptest.NewServer(val.RPCHandler())
+	defer server.Close()
+
+	result, rpcErr := callTestRPC(t, server.URL, "status", "")
+	var status StatusInfo
+	if rpcErr != nil || json.Unmarshal(result, &status) != nil {
+		t.Fatal("status failed:", rpcErr)
+	}
+	if status.ID != 1 || status.Height != 4 || status.State != "Final" || status.Hash != "0102" {
+		t.Error("Wrong status:", status)
+	}
+
+	if _, rpcErr = callTestRPC(t, server.URL, "block", `{"height":3}`); rpcErr == nil || rpcErr.Code != RPCNotFound {
+		t.Error("Expected block not found, got", rpcErr)
+	}
+	if _, rpcErr = callTestRPC(t, server.URL, "nonsense", ""); rpcErr == nil || rpcErr.Code != RPCMethodNotFound {
+		t.Error("Expected method not found, got", rpcErr)
+	}
+
+	if _, rpcErr = callTestRPC(t, server.URL, "setLogLevel", `{"level":"warning"}`); rpcErr != nil {
+		t.Error("setLogLevel failed:", rpcErr)
+	}
+	if val.log.Level != logrus.WarnLevel {
+		t.Error("Log level not set:", val.log.Level)
+	}
+
+	if _, rpcErr = callTestRPC(t, server.URL, "setGossipParams", `{"epochLen":"20ms","mode":"pushpull"}`); rpcErr != nil {
+		t.Error("setGossipParams failed:", rpcErr)
+	}
+	params := val.GossipParams()
+	if params.BranchFactor != 2 || params.EpochLen != 20*time.Millisecond || params.Mode != GossipPushPull {
+		t.Error("Wrong gossip params:", params)
+	}
+	if _, rpcErr = callTestRPC(t, server.URL, "setGossipParams", `{"branchFactor":0}`); rpcErr == nil || rpcErr.Code != RPCInvalidParams {
+		t.Error("Expected invalid params, got", rpcErr)
+	}
+
+	big := `{"jsonrpc":"2.0","id":1,"method":"status","params":"` + strings.Repeat("x", MaxRPCBodySize) + `"}`
+	resp, err := http.Post(server.URL, "application/json", strings.NewReader(big))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var r rpcResponse
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil || r.Error == nil || r.Error.Code != RPCParseError {
+		t.Error("Oversized request not rejected:", err, r.Error)
+	}
+}
+
+func TestRPCAccess(t *testing.T) {
+	val := &Validator{id: 1, log: logrus.New(), blockHeight: 4, state: StateFinal, hash: []byte{1, 2}}
+	val.SetLogOutput(&bytes.Buffer{})
+	server := httptest.NewServer(val.RPCHandler())
+	defer server.Close()
+	body := `{"jsonrpc":"2.0","id":1,"method":"setLogLevel","params":{"level":"debug"}}`
+
+	// A form post, as any web page can send, is refused
+	resp, err := http.Post(server.URL, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Error("Request without application/json accepted:", resp.Status)
+	}
+
+	// So is a request for another host, as after DNS rebinding
+	req, _ := http.NewRequest(http.MethodPost, server.URL, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Host = "attacker.example:80"
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Error("Request for a remote host accepted:", resp.Status)
+	}
+	if val.log.IsLevelEnabled(logrus.DebugLevel) {
+		t.Error("Log level changed.")
+	}
+
+	// The public API answers any host, but not the admin calls
+	public := httptest.NewServer(val.PublicRPCHandler())
+	defer public.Close()
+	if _, rpcErr := callTestRPC(t, public.URL, "setLogLevel", `{"level":"debug"}`); rpcErr == nil || rpcErr.Code != RPCMethodNotFound {
+		t.Error("Admin call served publicly:", rpcErr)
+	}
+	req, _ = http.NewRequest(http.MethodPost, public.URL, strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"status"}`))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Host = "validator.example"
+	if resp, err = http.DefaultClient.Do(req); err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Error("Public status call refused:", resp.Status)
+	}
+}
@@ -0,0 +1,177 @@
+package PairBFT
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// GossipSchedule bounds the adaptive gossip scheduler. The validator sends as
+// soon as its state changes; while nothing changes, the interval between
+// sends doubles up to MaxInterval, and if the validator is collecting votes,
+// after StallSends such sends the fan-out grows by one up to MaxBranchFactor.
+// An idle or finalized validator has nothing new to spread and stays at
+// MinBranchFactor.
+type (
+	GossipSchedule struct {
+		MinInterval     time.Duration
+		MaxInterval     time.Duration
+		MinBranchFactor int
+		MaxBranchFactor int
+		StallSends      int
+	}
+
+	gossipScheduler struct {
+		schedule     GossipSchedule
+		interval     time.Duration
+		branchFactor int
+		idleSends    int
+	}
+
+	// GossipParams are the gossip settings that can change while the
+	// validator runs. BranchFactor and EpochLen are ignored under a schedule.
+	GossipParams struct {
+		BranchFactor int
+		EpochLen     time.Duration
+		Mode         int
+	}
+
+	progressMark struct {
+		blockHeight uint64
+		state       int
+		numSigners  int
+	}
+)
+
+var (
+	ErrInvalidGossipParams   = errors.New("invalid gossip parameters")
+	ErrInvalidGossipSchedule = errors.New("invalid gossip schedule")
+)
+
+func (s *GossipSchedule) validate() error {
+	if s.MinInterval <= 0 || s.MaxInterval < s.MinInterval {
+		return errors.New("invalid schedule intervals")
+	}
+	if s.MinBranchFactor < 1 || s.MaxBranchFactor < s.MinBranchFactor || s.StallSends < 1 {
+		return errors.New("invalid schedule branch factors")
+	}
+	return nil
+}
+
+func (s *gossipScheduler) init(schedule GossipSchedule) {
+	s.schedule = schedule
+	s.interval = schedule.MinInterval
+	s.branchFactor = schedule.MinBranchFactor
+	s.idleSends = 0
+}
+
+// update sets the pace of the next send, depending on whether the validator
+// made progress since the last one, and whether it is collecting votes.
+func (s *gossipScheduler) update(progressed bool, collecting bool) {
+	if progressed {
+		s.interval = s.schedule.MinInterval
+		s.branchFactor = s.schedule.MinBranchFactor
+		s.idleSends = 0
+		return
+	}
+
+	s.interval *= 2
+	if s.interval > s.schedule.MaxInterval {
+		s.interval = s.schedule.MaxInterval
+	}
+	if !collecting {
+		s.branchFactor = s.schedule.MinBranchFactor
+		s.idleSends = 0
+		return
+	}
+	s.idleSends++
+	if s.idleSends >= s.schedule.StallSends && s.branchFactor < s.schedule.MaxBranchFactor {
+		s.branchFactor++
+		s.idleSends = 0
+	}
+}
+
+// SetGossipSchedule replaces the fixed epochLen and branch factor with the
+// adaptive scheduler, or restores them if schedule is nil. It must be called
+// before Start.
+func (val *Validator) SetGossipSchedule(schedule *GossipSchedule) error {
+	if schedule != nil {
+		if err := schedule.validate(); err != nil {
+			return ErrInvalidGossipSchedule
+		}
+	}
+	val.schedule = schedule
+	return nil
+}
+
+func (val *Validator) GossipParams() GossipParams {
+	val.gossipMutex.Lock()
+	defer val.gossipMutex.Unlock()
+	return GossipParams{
+		BranchFactor: val.branchFactor,
+		EpochLen:     val.epochLen,
+		Mode:         val.gossipMode,
+	}
+}
+
+// SetGossipParams changes the gossip settings; the new epochLen applies from
+// the next send.
+func (val *Validator) SetGossipParams(params GossipParams) error {
+	if val.schedule == nil && (params.BranchFactor < 1 || params.EpochLen <= 0) {
+		return ErrInvalidGossipParams
+	}
+	if params.Mode != GossipPush && params.Mode != GossipPushPull {
+		return ErrInvalidGossipParams
+	}
+	val.gossipMutex.Lock()
+	defer val.gossipMutex.Unlock()
+	val.branchFactor = params.BranchFactor
+	val.epochLen = params.EpochLen
+	val.gossipMode = params.Mode
+	return nil
+}
+
+func (val *Validator) getProgressMark() progressMark {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+	mark := progressMark{blockHeight: val.blockHeight, state: val.state}
+	if val.aggSig != nil {
+		mark.numSigners = val.aggSig.NumSigners()
+	}
+	return mark
+}
+
+// collecting tells whether the validator is gathering the votes of a height,
+// the only time a stall calls for a larger fan-out.
+func (val *Validator) collecting() bool {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+	switch val.state {
+	case StatePrepared, StateCommitted, StateCommitPrepared:
+		return true
+	}
+	return false
+}
+
+func (val *Validator) notifyProgress() {
+	select {
+	case val.progress <- true:
+	default:
+	}
+}
+
+func (val *Validator) runSchedule(ctx context.Context) {
+	s := &gossipScheduler{}
+	s.init(*val.schedule)
+	for epoch := 0; val.debugEpochLimit == 0 || epoch < val.debugEpochLimit; epoch++ {
+		val.sendTo(s.branchFactor)
+		select {
+		case <-ctx.Done():
+			return
+		case <-val.progress:
+			s.update(true, true)
+		case <-time.After(s.interval):
+			s.update(false, val.collecting())
+		}
+	}
+}
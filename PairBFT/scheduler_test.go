@@ -0,0 +1,85 @@
+package PairBFT
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGossipSchedulerBackoff(t *testing.T) {
+	schedule := GossipSchedule{
+		MinInterval:     10 * time.Millisecond,
+		MaxInterval:     80 * time.Millisecond,
+		MinBranchFactor: 1,
+		MaxBranchFactor: 3,
+		StallSends:      2,
+	}
+	s := &gossipScheduler{}
+	s.init(schedule)
+
+	intervals := []time.Duration{20, 40, 80, 80, 80, 80}
+	branchFactors := []int{1, 2, 2, 3, 3, 3}
+	for i := range intervals {
+		s.update(false, true)
+		if s.interval != intervals[i]*time.Millisecond {
+			t.Error("Interval after ", i+1, " idle sends: ", s.interval)
+		}
+		if s.branchFactor != branchFactors[i] {
+			t.Error("Branch factor after ", i+1, " idle sends: ", s.branchFactor)
+		}
+	}
+
+	s.update(true, true)
+	if s.interval != schedule.MinInterval || s.branchFactor != schedule.MinBranchFactor {
+		t.Error("Progress does not reset the schedule.")
+	}
+
+	// Without votes to collect only the interval backs off
+	for i := 0; i < 5; i++ {
+		s.update(false, false)
+	}
+	if s.interval != schedule.MaxInterval || s.branchFactor != schedule.MinBranchFactor {
+		t.Error("Idle validator escalated: ", s.interval, s.branchFactor)
+	}
+
+	v := &Validator{}
+	for _, bad := range []GossipSchedule{
+		{MinInterval: 0, MaxInterval: time.Second, MinBranchFactor: 1, MaxBranchFactor: 1, StallSends: 1},
+		{MinInterval: time.Second, MaxInterval: time.Second, MinBranchFactor: 0, MaxBranchFactor: 1, StallSends: 1},
+	} {
+		bad := bad
+		if v.SetGossipSchedule(&bad) == nil {
+			t.Error("Invalid schedule accepted: ", bad)
+		}
+	}
+	if v.SetGossipSchedule(&schedule) != nil {
+		t.Error("Valid schedule rejected.")
+	}
+}
+
+func TestPairBFT_adaptive_mem_n10(t *testing.T) {
+	numVals := 10
+	vals := genValidators(numVals, 1, 50*time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	schedule := &GossipSchedule{
+		MinInterval:     5 * time.Millisecond,
+		MaxInterval:     200 * time.Millisecond,
+		MinBranchFactor: 1,
+		MaxBranchFactor: 4,
+		StallSends:      3,
+	}
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+		if err := vals[i].SetGossipSchedule(schedule); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runValidators(vals, 200, false)
+	for i := 0; i < numVals; i++ {
+		if finalizedHeight(vals[i]) < 1 {
+			t.Error("Validator ", i, " finalized nothing.")
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package PairBFT
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"math/big"
+	"net"
+	"time"
+)
+
+var (
+	ErrPeerKeyMismatch = errors.New("peer node key does not match validator set")
+)
+
+// SecureTransport runs the TCP framing over TLS 1.3. Every validator presents a
+// self-signed certificate for its ed25519 node key, and both ends accept only
+// the node keys listed in the validator set.
+type (
+	SecureTransport struct {
+		TCPTransport
+		peerKeys []ed25519.PublicKey
+	}
+)
+
+func GenNodeKey() (ed25519.PublicKey, ed25519.PrivateKey) {
+	pubKey, privKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	return pubKey, privKey
+}
+
+func (t *SecureTransport) Init(id int, book *AddrBook, nodeKey ed25519.PrivateKey, peerKeys []ed25519.PublicKey) error {
+	cert, err := selfSignedCert(nodeKey)
+	if err != nil {
+		return err
+	}
+	t.peerKeys = peerKeys
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.RequireAnyClientCert,
+		MinVersion:   tls.VersionTLS13,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			if t.peerIndex(rawCerts) < 0 {
+				return ErrPeerKeyMismatch
+			}
+			return nil
+		},
+	}
+	listener, err := tls.Listen("tcp", book.Addr(id), serverConfig)
+	if err != nil {
+		return err
+	}
+
+	dial := func(peer int, addr string) (net.Conn, error) {
+		clientConfig := &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS13,
+			// The certificate chain is not checked against any CA; the node key is pinned instead
+			InsecureSkipVerify: true,
+			VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+				if t.peerIndex(rawCerts) != peer {
+					return ErrPeerKeyMismatch
+				}
+				return nil
+			},
+		}
+		dialer := &net.Dialer{Timeout: tcpDialTimeout}
+		return tls.DialWithDialer(dialer, "tcp", addr, clientConfig)
+	}
+
+	t.init(id, book, listener, dial)
+	t.authenticate = t.checkPeer
+	return nil
+}
+
+// checkPeer makes sure the index announced by a dialing peer belongs to the
+// node key it authenticated with.
+func (t *SecureTransport) checkPeer(conn net.Conn, peer int) bool {
+	tlsConn, ok := conn.(*tls.Conn)
+	if !ok {
+		return false
+	}
+	state := tlsConn.ConnectionState()
+	if len(state.PeerCertificates) == 0 {
+		return false
+	}
+	return t.peerIndex([][]byte{state.PeerCertificates[0].Raw}) == peer
+}
+
+func (t *SecureTransport) peerIndex(rawCerts [][]byte) int {
+	if len(rawCerts) == 0 {
+		return -1
+	}
+	cert, err := x509.ParseCertificate(rawCerts[0])
+	if err != nil {
+		return -1
+	}
+	key, ok := cert.PublicKey.(ed25519.PublicKey)
+	if !ok {
+		return -1
+	}
+	if cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) != nil {
+		return -1
+	}
+	for i, peerKey := range t.peerKeys {
+		if bytes.Equal(key, peerKey) {
+			return i
+		}
+	}
+	return -1
+}
+
+func selfSignedCert(nodeKey ed25519.PrivateKey) (tls.Certificate, error) {
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "PairBFT validator"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(10 * 365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, nodeKey.Public(), nodeKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: nodeKey}, nil
+}
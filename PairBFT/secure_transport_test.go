@@ -0,0 +1,56 @@
+package PairBFT
+
+import (
+	"crypto/ed25519"
+	"testing"
+	"time"
+)
+
+func genSecureTransports(t *testing.T, book *AddrBook, nodeKeys []ed25519.PrivateKey, peerKeys []ed25519.PublicKey) []Transport {
+	transports := make([]Transport, len(nodeKeys))
+	for i := range transports {
+		tr := &SecureTransport{}
+		if err := tr.Init(i, book, nodeKeys[i], peerKeys); err != nil {
+			t.Fatal(err)
+		}
+		transports[i] = tr
+	}
+	return transports
+}
+
+func TestSecureTransport(t *testing.T) {
+	book := genTestAddrBook(2, 2120)
+	pubKeys := make([]ed25519.PublicKey, 2)
+	privKeys := make([]ed25519.PrivateKey, 2)
+	for i := range pubKeys {
+		pubKeys[i], privKeys[i] = GenNodeKey()
+	}
+	checkTransports(t, genSecureTransports(t, book, privKeys, pubKeys))
+}
+
+func TestSecureTransportRejectsUnknownKey(t *testing.T) {
+	book := genTestAddrBook(2, 2130)
+	pubKeys := make([]ed25519.PublicKey, 2)
+	privKeys := make([]ed25519.PrivateKey, 2)
+	for i := range pubKeys {
+		pubKeys[i], privKeys[i] = GenNodeKey()
+	}
+	// Validator 0 runs with a key that is not in the validator set
+	_, privKeys[0] = GenNodeKey()
+	transports := genSecureTransports(t, book, privKeys, pubKeys)
+	defer func() {
+		for _, tr := range transports {
+			tr.Close()
+		}
+	}()
+
+	transports[0].Send(1, []byte{MsgTypePrepare})
+	if transports[1].Send(0, []byte{MsgTypePrepare}) == nil {
+		t.Error("Connected to a peer with an unknown node key.")
+	}
+	select {
+	case <-transports[1].Receive():
+		t.Error("Accepted a message from a peer with an unknown node key.")
+	case <-time.After(500 * time.Millisecond):
+	}
+}
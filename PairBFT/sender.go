@@ -2,22 +2,53 @@ package PairBFT
 
 import (
 	"math/rand"
-	"strconv"
-	"net"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
 )
 
-// Randomly choose another validator
+// Choose another validator with the peer selector
 func (val *Validator) chooseRcpt() int {
-	numVals := len(val.valAddrSet)
-	// todo: replace math.rand with a secure random function
-	rcpt := int(rand.Uint32()) % (numVals - 1)
-	if rcpt >= val.id {
-		rcpt ++
+	return val.peerSelector.ChooseRcpt(val.gossipView())
+}
+
+func (val *Validator) gossipView() *GossipView {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+
+	view := &GossipView{
+		Self:        val.id,
+		NumVals:     len(val.valAddrSet),
+		BlockHeight: val.blockHeight,
+		MsgType:     stateMsgType(val.state),
+	}
+	view.Counters = make([]uint32, view.NumVals)
+	if val.aggSig != nil {
+		copy(view.Counters, val.aggSig.counters)
 	}
-	return rcpt
+	return view
+}
+
+func stateMsgType(state int) byte {
+	switch state {
+	case StatePrepared:
+		return MsgTypePrepare
+	case StateCommitted, StateFinal:
+		return MsgTypeCommit
+	case StateCommitPrepared, StateFinalPrepared:
+		return MsgTypeCommitPrepare
+	}
+	return MsgTypeUnknown
 }
 
 func (val *Validator) genMsgData(rcpt int) []byte {
+	data, _ := val.genMsg(rcpt)
+	return data
+}
+
+// genMsg returns the current message for rcpt, along with the view of
+// what the message tells rcpt.
+func (val *Validator) genMsg(rcpt int) ([]byte, *GossipView) {
 	val.stateMutex.Lock()
 	defer val.stateMutex.Unlock()
 
@@ -25,35 +56,98 @@ func (val *Validator) genMsgData(rcpt int) []byte {
 		data []byte
 	)
 
+	// After a sync the validator holds only the certificate of its block, and
+	// has nothing to send until it moves on
+	if val.prevAggSig == nil && (val.state == StateCommitted || val.state == StateFinal || val.blockHeight > 1) {
+		return nil, nil
+	}
+
 	switch val.state {
 	case StatePrepared:
 		data = MsgBytesFromData(MsgTypePrepare, val.blockHeight, val.hash, val.prevAggSig, val.aggSig)
-		val.log.Debug("Prepare->", strconv.Itoa(rcpt), "@", val.blockHeight, ":", val.aggSig.counters)
 	case StateCommitted, StateFinal:
 		data = MsgBytesFromData(MsgTypeCommit, val.blockHeight, val.hash, val.aggSig, val.prevAggSig)
-		val.log.Debug("Commit->", strconv.Itoa(rcpt), "@", val.blockHeight, ":", val.aggSig.counters)
 	case StateCommitPrepared, StateFinalPrepared:
 		data = MsgBytesFromData(MsgTypeCommitPrepare, val.blockHeight, val.hash, val.prevAggSig, val.aggSig)
-		val.log.Debug("CommitPrepare->", strconv.Itoa(rcpt), "@", val.blockHeight, ":", val.aggSig.counters)
 	}
-	return data
+	if data == nil {
+		return nil, nil
+	}
+	if val.log.IsLevelEnabled(logrus.DebugLevel) {
+		fields := val.stateFields()
+		fields["peer"] = rcpt
+		fields["counters"] = val.aggSig.counters
+		val.log.WithFields(fields).Debug("Send")
+	}
+	view := &GossipView{
+		Self:        val.id,
+		NumVals:     len(val.valAddrSet),
+		BlockHeight: val.blockHeight,
+		MsgType:     data[0],
+		Counters:    append([]uint32(nil), val.aggSig.counters...),
+	}
+	return data, view
+}
+
+// sendMsg sends the current message to rcpt. Only a message that was
+// actually sent tells the peer selector what rcpt knows.
+func (val *Validator) sendMsg(rcpt int) {
+	data, view := val.genMsg(rcpt)
+	if data != nil && val.sendData(rcpt, data) {
+		val.peerSelector.Sent(rcpt, view.BlockHeight, view.MsgType, view.Counters)
+	}
+}
+
+// sendData tells whether data was handed to the transport.
+func (val *Validator) sendData(rcpt int, data []byte) bool {
+	if !val.allowSend(data) {
+		val.log.WithFields(logrus.Fields{"peer": rcpt, "msgType": MsgTypeName(data[0])}).Debug("Bandwidth exceeded, dropped")
+		return false
+	}
+	if err := val.transport.Send(rcpt, data); err != nil {
+		val.log.WithField("peer", rcpt).Error("Error sending: ", err)
+		return false
+	}
+	countMsg(&val.metrics.msgsSent, data)
+	return true
 }
 
-func (val *Validator) sendData(rcpt int, data []byte) {
-	conn, err := net.Dial("udp", val.valAddrSet[rcpt])
-	if err != nil {
-		val.log.Panic("Error connecting to validator: ", err)
+// Pass on a signed address record, our own one half of the time
+func (val *Validator) gossipAddrRecord() {
+	numVals := len(val.valAddrSet)
+	peer := val.id
+	if rand.Intn(2) == 0 {
+		peer = rand.Intn(numVals)
 	}
-	conn.Write(data)
-	conn.Close()
+	rec := val.addrBook.Record(peer)
+	if rec == nil || rec.Sig == nil {
+		return
+	}
+	val.sendData(chooseRandomPeer(val.id, numVals), rec.Bytes())
 }
 
 func (val *Validator) Send() {
-	for i := 0; i < val.branchFactor; i++ {
-		rcpt := val.chooseRcpt()
-		data := val.genMsgData(rcpt)
-		if data != nil {
-			val.sendData(rcpt, data)
+	val.sendTo(val.GossipParams().BranchFactor)
+}
+
+// sendTo sends the current message to branchFactor peers, or to the
+// structured recipients in star and tree mode.
+func (val *Validator) sendTo(branchFactor int) {
+	if val.observer {
+		val.observerSend()
+		return
+	}
+	if val.dissemination == DisseminateGossip {
+		for i := 0; i < branchFactor; i++ {
+			val.sendMsg(val.chooseRcpt())
 		}
+	} else {
+		for _, rcpt := range val.structuredRcpts() {
+			val.sendMsg(rcpt)
+		}
+	}
+	val.sendToObserver()
+	if atomic.AddUint64(&val.sendEpoch, 1)%AddrGossipEpochs == 0 {
+		val.gossipAddrRecord()
 	}
 }
@@ -0,0 +1,227 @@
+package PairBFT
+
+import (
+	"errors"
+	"sync"
+)
+
+// Subscribers receive the finalized blocks in increasing height order, with
+// gaps only where the validator skipped blocks through a sync. The validator
+// never waits for them: it keeps the last RecentBlocks blocks in memory, and
+// a subscriber that falls further behind catches up from the BlockStore, or
+// is dropped with ErrSubscriberLagging if there is none.
+type (
+	Subscription struct {
+		C <-chan *FinalizedBlock // closed when the subscription ends, see Err
+
+		c      chan *FinalizedBlock
+		feed   *blockFeed
+		store  BlockStore
+		next   uint64 // height of the next block to deliver, 0 for the next finalized one
+		notify chan bool
+		done   chan struct{}
+		once   sync.Once
+		err    error
+	}
+
+	blockFeed struct {
+		mutex   sync.Mutex
+		recent  []*FinalizedBlock // oldest first
+		evicted uint64            // height of the last block dropped from recent
+		subs    map[*Subscription]bool
+		closed  bool
+	}
+)
+
+const (
+	RecentBlocks = 64
+)
+
+var (
+	ErrSubscriberLagging = errors.New("subscriber fell behind the recent blocks")
+	ErrValidatorStopped  = errors.New("validator stopped")
+)
+
+func (f *blockFeed) publish(block *FinalizedBlock) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.recent = append(f.recent, block)
+	if len(f.recent) > RecentBlocks {
+		f.evicted = f.recent[len(f.recent)-RecentBlocks-1].Height
+		f.recent = append([]*FinalizedBlock(nil), f.recent[len(f.recent)-RecentBlocks:]...)
+	}
+	for sub := range f.subs {
+		select {
+		case sub.notify <- true:
+		default:
+		}
+	}
+}
+
+// since returns the recent blocks from height next on, and the height of the
+// oldest recent block, 0 if there is none. lagging tells that blocks from
+// next on were dropped from the recent blocks.
+func (f *blockFeed) since(next uint64) (blocks []*FinalizedBlock, oldest uint64, lagging bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, block := range f.recent {
+		if block.Height >= next {
+			blocks = append(blocks, block)
+		}
+	}
+	if len(f.recent) > 0 {
+		oldest = f.recent[0].Height
+	}
+	return blocks, oldest, next <= f.evicted
+}
+
+func (f *blockFeed) latest() uint64 {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if len(f.recent) == 0 {
+		return 0
+	}
+	return f.recent[len(f.recent)-1].Height
+}
+
+// close ends all subscriptions with ErrValidatorStopped.
+func (f *blockFeed) close() {
+	f.mutex.Lock()
+	f.closed = true
+	subs := f.subs
+	f.subs = nil
+	f.mutex.Unlock()
+	for sub := range subs {
+		sub.end(ErrValidatorStopped)
+	}
+}
+
+// Subscribe delivers the finalized blocks from height fromHeight on, or from
+// the next one to be finalized if fromHeight is 0. Past blocks come from the
+// BlockStore. C has room for bufSize blocks.
+func (val *Validator) Subscribe(fromHeight uint64, bufSize int) *Subscription {
+	c := make(chan *FinalizedBlock, bufSize)
+	sub := &Subscription{
+		C:      c,
+		c:      c,
+		feed:   &val.blocks,
+		store:  val.store,
+		next:   fromHeight,
+		notify: make(chan bool, 1),
+		done:   make(chan struct{}),
+	}
+	if sub.next == 0 {
+		sub.next = val.blocks.latest() + 1
+	}
+
+	f := &val.blocks
+	f.mutex.Lock()
+	if f.closed {
+		f.mutex.Unlock()
+		sub.end(ErrValidatorStopped)
+		close(sub.c)
+		return sub
+	}
+	if f.subs == nil {
+		f.subs = make(map[*Subscription]bool)
+	}
+	f.subs[sub] = true
+	f.mutex.Unlock()
+
+	sub.notify <- true
+	go sub.run()
+	return sub
+}
+
+// Close ends the subscription; C is closed shortly after.
+func (sub *Subscription) Close() {
+	sub.end(nil)
+}
+
+// Err returns why the subscription ended, once C is closed; nil after Close.
+func (sub *Subscription) Err() error {
+	select {
+	case <-sub.done:
+		return sub.err
+	default:
+		return nil
+	}
+}
+
+func (sub *Subscription) end(err error) {
+	sub.once.Do(func() {
+		sub.err = err
+		close(sub.done)
+	})
+}
+
+func (sub *Subscription) run() {
+	defer func() {
+		sub.feed.mutex.Lock()
+		delete(sub.feed.subs, sub)
+		sub.feed.mutex.Unlock()
+		close(sub.c)
+	}()
+	for {
+		select {
+		case <-sub.notify:
+		case <-sub.done:
+			return
+		}
+		for {
+			blocks, err := sub.pending()
+			if err != nil {
+				sub.end(err)
+				return
+			}
+			if len(blocks) == 0 {
+				break
+			}
+			for _, block := range blocks {
+				select {
+				case sub.c <- block:
+					sub.next = block.Height + 1
+				case <-sub.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// pending returns the next blocks to deliver, from the recent blocks or, if
+// the subscriber is behind them, from the store.
+func (sub *Subscription) pending() ([]*FinalizedBlock, error) {
+	blocks, oldest, lagging := sub.feed.since(sub.next)
+	if sub.store == nil {
+		if lagging {
+			return nil, ErrSubscriberLagging
+		}
+		return blocks, nil
+	}
+
+	end := oldest
+	if end == 0 {
+		end = sub.store.Height() + 1
+	}
+	if sub.next >= end {
+		return blocks, nil
+	}
+	// Up to RecentBlocks at a time; heights the store has no block for were
+	// skipped by a sync
+	blocks = nil
+	for h := sub.next; h < end && len(blocks) < RecentBlocks; h++ {
+		block, err := sub.store.Get(h)
+		if err == ErrBlockNotFound {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		blocks = append(blocks, block)
+	}
+	if len(blocks) == 0 {
+		sub.next = end
+		return sub.pending()
+	}
+	return blocks, nil
+}
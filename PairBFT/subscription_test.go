@@ -0,0 +1,121 @@
+package PairBFT
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func publishTestBlocks(val *Validator, from uint64, to uint64) {
+	for h := from; h <= to; h++ {
+		val.storeBlock(&FinalizedBlock{Height: h, Hash: []byte{byte(h)}})
+	}
+}
+
+func expectBlocks(t *testing.T, sub *Subscription, heights ...uint64) {
+	for _, h := range heights {
+		select {
+		case block, ok := <-sub.C:
+			if !ok {
+				t.Fatal("Subscription ended before block", h, ":", sub.Err())
+			}
+			if block.Height != h {
+				t.Fatal("Expected block", h, "got", block.Height)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timed out waiting for block", h)
+		}
+	}
+}
+
+func expectEnd(t *testing.T, sub *Subscription, err error) {
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case _, ok := <-sub.C:
+			if !ok {
+				if sub.Err() != err {
+					t.Error("Expected", err, "got", sub.Err())
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Subscription did not end")
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	val := &Validator{}
+	sub := val.Subscribe(0, 1)
+	publishTestBlocks(val, 1, 3)
+	expectBlocks(t, sub, 1, 2, 3)
+	sub.Close()
+	expectEnd(t, sub, nil)
+
+	// Without a store, a subscriber left behind by the recent blocks is dropped
+	sub = val.Subscribe(0, 0)
+	publishTestBlocks(val, 4, 4+RecentBlocks+10)
+	expectEnd(t, sub, ErrSubscriberLagging)
+
+	// With one, it catches up from the store, skipping heights lost to a sync
+	store := &MemBlockStore{}
+	store.Init()
+	val = &Validator{store: store}
+	publishTestBlocks(val, 1, 5)
+	sub = val.Subscribe(2, 0)
+	publishTestBlocks(val, 9, 9+RecentBlocks)
+	heights := []uint64{2, 3, 4, 5}
+	for h := uint64(9); h <= 9+RecentBlocks; h++ {
+		heights = append(heights, h)
+	}
+	expectBlocks(t, sub, heights...)
+
+	val.blocks.close()
+	expectEnd(t, sub, ErrValidatorStopped)
+	if sub = val.Subscribe(0, 0); sub.Err() != ErrValidatorStopped {
+		t.Error("Subscribed to a stopped validator")
+	}
+}
+
+func TestBlockStream(t *testing.T) {
+	bls := &BLS{}
+	bls.Init()
+	store := &MemBlockStore{}
+	store.Init()
+	val := &Validator{store: store, chainID: "test"}
+	for h := uint64(1); h <= 3; h++ {
+		sig := &AggSig{}
+		sig.Init(bls, 4)
+		val.storeBlock(&FinalizedBlock{Height: h, Hash: []byte{byte(h)}, AggSig: sig, Phase: MsgTypeCommit})
+	}
+
+	server := httptest.NewServer(val.BlockStreamHandler())
+	defer server.Close()
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"?from=2", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	for h := uint64(2); h <= 3; h++ {
+		var info struct {
+			Height      uint64
+			Counters    []uint32
+			Certificate json.RawMessage
+		}
+		if err := conn.ReadJSON(&info); err != nil {
+			t.Fatal(err)
+		}
+		cert := &FinalityCertificate{}
+		if err := cert.SetJSON(bls, info.Certificate); err != nil {
+			t.Fatal(err)
+		}
+		if info.Height != h || len(info.Counters) != 4 || cert.Height != h || cert.ChainID != "test" {
+			t.Error("Wrong block:", info.Height, info.Counters, cert)
+		}
+	}
+}
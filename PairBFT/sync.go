@@ -0,0 +1,400 @@
+package PairBFT
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Messages for heights the validator cannot handle yet are kept in a pending
+// buffer and handled again once it advances. A message two or more heights
+// ahead means the validator missed a whole block; it then asks the sender
+// for the certificate of the last block the sender finalized, which is the
+// quorum aggregate that finalized it: the Commit aggregate, or in
+// CommitPrepare mode the CommitPrepare aggregate of the block after it. The
+// certificate comes with the hash of the parent of the certified block and
+// the data of the certified block, which must hash to the certified hash.
+type (
+	pendingMsg struct {
+		peer int
+		data []byte
+	}
+
+	pendingBuffer struct {
+		mutex     sync.Mutex
+		msgs      map[uint64][]pendingMsg
+		bytes     int
+		peerBytes map[int]int
+		maxBytes  int
+	}
+
+	syncCert struct {
+		blockHeight    uint64
+		hash, prevHash []byte
+		aggSig         *AggSig
+		data           []byte
+	}
+)
+
+const (
+	MaxPendingHeights = 16
+	MaxPendingBytes   = 4 << 20
+	SyncGap           = 2
+	SyncInterval      = 500 * time.Millisecond
+	SyncTimeout       = 4 * SyncInterval
+
+	lenSyncNonce      = 8
+	LenSyncRequest    = LenMsgType + LenBlockHeight + lenSyncNonce
+	lenSyncRespHeader = LenMsgType + LenBlockHeight + LenHash + LenHash
+)
+
+func (b *pendingBuffer) init(maxBytes int) {
+	b.msgs = make(map[uint64][]pendingMsg)
+	b.bytes = 0
+	b.peerBytes = make(map[int]int)
+	b.maxBytes = maxBytes
+}
+
+// put keeps data for blockHeight. If the buffer is full, it evicts the
+// messages of the highest heights from the peer that buffered the most, so
+// that a peer flooding it only crowds out its own messages.
+func (b *pendingBuffer) put(blockHeight uint64, peer int, data []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.msgs[blockHeight] = append(b.msgs[blockHeight], pendingMsg{peer, data})
+	b.bytes += len(data)
+	b.peerBytes[peer] += len(data)
+	for b.bytes > b.maxBytes {
+		greediest := peer
+		for p, n := range b.peerBytes {
+			if n > b.peerBytes[greediest] {
+				greediest = p
+			}
+		}
+		b.evict(greediest)
+	}
+}
+
+// evict drops the last message of peer at the highest height it has one.
+func (b *pendingBuffer) evict(peer int) {
+	highest, found := uint64(0), false
+	for h, msgs := range b.msgs {
+		if found && h <= highest {
+			continue
+		}
+		for _, msg := range msgs {
+			if msg.peer == peer {
+				highest, found = h, true
+				break
+			}
+		}
+	}
+	msgs := b.msgs[highest]
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].peer == peer {
+			b.remove(msgs[i])
+			msgs = append(msgs[:i], msgs[i+1:]...)
+			break
+		}
+	}
+	if len(msgs) == 0 {
+		delete(b.msgs, highest)
+	} else {
+		b.msgs[highest] = msgs
+	}
+}
+
+func (b *pendingBuffer) remove(msg pendingMsg) {
+	b.bytes -= len(msg.data)
+	b.peerBytes[msg.peer] -= len(msg.data)
+	if b.peerBytes[msg.peer] == 0 {
+		delete(b.peerBytes, msg.peer)
+	}
+}
+
+// take removes and returns the messages up to maxHeight, dropping those
+// below minHeight.
+func (b *pendingBuffer) take(minHeight uint64, maxHeight uint64) []pendingMsg {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	var taken []pendingMsg
+	for h := minHeight; h <= maxHeight; h++ {
+		taken = append(taken, b.msgs[h]...)
+	}
+	for h, msgs := range b.msgs {
+		if h <= maxHeight {
+			for _, msg := range msgs {
+				b.remove(msg)
+			}
+			delete(b.msgs, h)
+		}
+	}
+	return taken
+}
+
+func (b *pendingBuffer) len() int {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	n := 0
+	for _, msgs := range b.msgs {
+		n += len(msgs)
+	}
+	return n
+}
+
+// deferFuture buffers msg if it is about a height the validator cannot
+// handle yet, and asks peer for a sync if it is too far ahead. Only messages
+// from known peers are buffered; their counters have been checked by
+// isMalformed, but not their signatures.
+func (val *Validator) deferFuture(peer int, msg *Msg, data []byte) bool {
+	val.stateMutex.Lock()
+	ahead := msg.blockHeight > val.blockHeight+1
+	// A Commit for the next height does not finalize the current one
+	if msg.msgType == MsgTypeCommit && msg.blockHeight > 1 && msg.blockHeight > val.blockHeight && val.state != StateFinal {
+		ahead = true
+	}
+	if !ahead {
+		val.stateMutex.Unlock()
+		return false
+	}
+	if peer < 0 || peer >= val.numPeers() || peer == val.id {
+		val.stateMutex.Unlock()
+		return true
+	}
+	if msg.blockHeight > val.peerHeight {
+		val.peerHeight = msg.blockHeight
+	}
+	if msg.blockHeight <= val.blockHeight+MaxPendingHeights {
+		val.pending.put(msg.blockHeight, peer, data)
+	}
+	needSync := msg.blockHeight >= val.blockHeight+SyncGap || msg.msgType == MsgTypeCommit
+	val.stateMutex.Unlock()
+
+	if needSync {
+		val.requestSync(peer)
+	}
+	return true
+}
+
+// replayPending handles the buffered messages the validator can now handle.
+func (val *Validator) replayPending() {
+	val.stateMutex.Lock()
+	blockHeight := val.blockHeight
+	val.stateMutex.Unlock()
+
+	for _, msg := range val.pending.take(blockHeight, blockHeight+1) {
+		val.handleMsgDataFrom(msg.peer, msg.data)
+	}
+}
+
+// certHeight returns the height of the last block we hold a certificate for.
+func (val *Validator) certHeight() uint64 {
+	if val.state == StateFinal || val.state == StateFinalPrepared || val.blockHeight == 0 {
+		return val.blockHeight
+	}
+	return val.blockHeight - 1
+}
+
+func (val *Validator) requestSync(peer int) {
+	if peer < 0 || peer >= val.numPeers() || peer == val.id {
+		return
+	}
+	now := time.Now()
+	val.stateMutex.Lock()
+	if now.Sub(val.lastSyncRequest) < SyncInterval {
+		val.stateMutex.Unlock()
+		return
+	}
+	val.lastSyncRequest = now
+	blockHeight := val.certHeight()
+	val.syncPeer = peer
+	val.syncHeight = blockHeight
+	val.stateMutex.Unlock()
+
+	data := make([]byte, LenSyncRequest)
+	data[0] = MsgTypeSyncRequest
+	binary.LittleEndian.PutUint64(data[LenMsgType:], blockHeight)
+	// Keeps repeated requests from being dropped as duplicates
+	rand.Read(data[LenMsgType+LenBlockHeight:])
+	val.log.WithFields(logrus.Fields{"peer": peer, "height": blockHeight}).Debug("SyncRequest")
+	val.sendData(peer, data)
+}
+
+// syncRequested tells whether a SyncResponse from peer for blockHeight
+// answers our last sync request, if it was sent within SyncTimeout.
+func (val *Validator) syncRequested(peer int, blockHeight uint64) bool {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+	return peer == val.syncPeer && blockHeight > val.syncHeight && time.Since(val.lastSyncRequest) < SyncTimeout
+}
+
+// recordCert keeps the certificate of the block just finalized, to answer
+// sync requests.
+func (val *Validator) recordCert() {
+	val.cert = &syncCert{
+		blockHeight: val.blockHeight,
+		hash:        val.hash,
+		prevHash:    val.prevHash,
+		aggSig:      val.aggSig,
+		data:        val.blockData,
+	}
+}
+
+// handleSyncRequest answers peer with our last certificate. Each peer is
+// answered at most once per SyncInterval.
+func (val *Validator) handleSyncRequest(peer int, data []byte) {
+	if len(data) != LenSyncRequest || peer < 0 || peer >= val.numPeers() || peer == val.id {
+		atomic.AddUint64(&val.inboundCounters.malformed, 1)
+		return
+	}
+	blockHeight := binary.LittleEndian.Uint64(data[LenMsgType:])
+
+	now := time.Now()
+	val.stateMutex.Lock()
+	cert := val.cert
+	if cert == nil || cert.blockHeight <= blockHeight || now.Sub(val.syncServed[peer]) < SyncInterval {
+		val.stateMutex.Unlock()
+		return
+	}
+	val.syncServed[peer] = now
+	val.stateMutex.Unlock()
+
+	resp := make([]byte, lenSyncRespHeader, lenSyncRespHeader+cert.aggSig.Len()+len(cert.data))
+	resp[0] = MsgTypeSyncResponse
+	binary.LittleEndian.PutUint64(resp[LenMsgType:], cert.blockHeight)
+	copy(resp[LenMsgType+LenBlockHeight:], cert.hash)
+	copy(resp[LenMsgType+LenBlockHeight+LenHash:], cert.prevHash)
+	resp = append(resp, cert.aggSig.Bytes()...)
+	resp = append(resp, cert.data...)
+	val.log.WithFields(logrus.Fields{"peer": peer, "height": cert.blockHeight}).Debug("SyncResponse")
+	val.sendData(peer, resp)
+}
+
+// handleSyncResponse verifies and applies a certificate in one go, for
+// callers that drive the state machine themselves.
+func (val *Validator) handleSyncResponse(peer int, data []byte) {
+	if cert := val.verifySyncResponse(peer, data); cert != nil {
+		val.applySyncCert(cert)
+	}
+}
+
+// verifySyncResponse decodes and verifies the certificate in data. It
+// returns nil unless the certificate is valid, answers our last sync request
+// to peer and is ahead of us. It does not change the validator state, so it
+// can run on the verify workers.
+func (val *Validator) verifySyncResponse(peer int, data []byte) *syncCert {
+	numVals := len(val.valAddrSet)
+	cert := &syncCert{aggSig: &AggSig{}}
+	cert.aggSig.Init(val.bls, numVals)
+	if len(data) < lenSyncRespHeader+cert.aggSig.Len() {
+		atomic.AddUint64(&val.inboundCounters.malformed, 1)
+		return nil
+	}
+	cert.blockHeight = binary.LittleEndian.Uint64(data[LenMsgType:])
+	i := LenMsgType + LenBlockHeight
+	cert.hash = append([]byte(nil), data[i:i+LenHash]...)
+	i += LenHash
+	if cert.blockHeight > 1 { // block 1 has no parent
+		cert.prevHash = append([]byte(nil), data[i:i+LenHash]...)
+	}
+	i += LenHash
+	i += cert.aggSig.SetBytes(data[i:])
+	cert.data = append([]byte(nil), data[i:]...)
+
+	if !val.syncRequested(peer, cert.blockHeight) {
+		atomic.AddUint64(&val.inboundCounters.useless, 1)
+		return nil
+	}
+	val.stateMutex.Lock()
+	behind := cert.blockHeight > val.certHeight()
+	val.stateMutex.Unlock()
+	if !behind || !cert.aggSig.ReachQuorum() {
+		return nil
+	}
+	if !bytes.Equal(getBlockHash(cert.data, cert.prevHash), cert.hash) {
+		atomic.AddUint64(&val.inboundCounters.malformed, 1)
+		return nil
+	}
+
+	nonce := NonceCommit
+	if val.useCommitPrepare {
+		nonce = NonceCommitPrepare
+	}
+	h := getNoncedHash(cert.hash, nonce)
+	if !cert.aggSig.VerifyPreprocessedWith(val.bls, val.bls.PreprocessHash(h), &val.aggKeys) {
+		val.log.WithField("height", cert.blockHeight).Error("Invalid sync certificate")
+		return nil
+	}
+	return cert
+}
+
+// applySyncCert moves the validator to the block of a verified certificate,
+// if it is still ahead of us, and handles the messages buffered meanwhile.
+func (val *Validator) applySyncCert(cert *syncCert) {
+	val.stateMutex.Lock()
+	if cert.blockHeight <= val.certHeight() {
+		val.stateMutex.Unlock()
+		return
+	}
+	val.applyCert(cert)
+	val.stateMutex.Unlock()
+
+	val.notifyProgress()
+	val.replayPending()
+}
+
+// applyCert moves the validator to the block of cert, as if it had just
+// finalized it, and stores the block cert finalizes. In CommitPrepare mode
+// that is the parent of the certified block, whose data is unknown.
+func (val *Validator) applyCert(cert *syncCert) {
+	val.blockHeight = cert.blockHeight
+	val.updateHash(cert.hash)
+	val.prevHash = cert.prevHash
+	val.blockData = cert.data
+	val.aggSig = cert.aggSig
+	// We hold no aggregate of the previous phase, see genMsgData
+	val.prevAggSig = nil
+	val.aggPool.Reset()
+	if val.useCommitPrepare {
+		val.state = StateFinalPrepared
+	} else {
+		val.state = StateFinal
+	}
+	val.recordCert()
+	val.transition("Synced", val.blockHeight, val.hash, val.aggSig)
+	if !val.useCommitPrepare {
+		val.storeBlock(&FinalizedBlock{
+			Height:     val.blockHeight,
+			Hash:       val.hash,
+			Data:       val.blockData,
+			AggSig:     val.aggSig,
+			Phase:      MsgTypeCommit,
+			ValSetHash: val.valSetHash,
+		})
+	} else if val.blockHeight > 1 {
+		val.storeBlock(&FinalizedBlock{
+			Height:     val.blockHeight - 1,
+			Hash:       val.prevHash,
+			AggSig:     val.aggSig,
+			ChildData:  val.blockData,
+			Phase:      MsgTypeCommitPrepare,
+			ValSetHash: val.valSetHash,
+		})
+	}
+
+	numVals := len(val.valAddrSet)
+	if getProposerID(val.blockHeight+1, numVals) == val.id {
+		if val.useCommitPrepare {
+			val.commitProposeBlock(val.blockHeight + 1)
+		} else {
+			val.proposeBlock(val.blockHeight + 1)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package PairBFT
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingBuffer(t *testing.T) {
+	b := &pendingBuffer{}
+	b.init(100)
+
+	// Peer 1 fills the buffer first
+	b.put(5, 1, make([]byte, 30))
+	b.put(4, 1, make([]byte, 30))
+	b.put(3, 1, make([]byte, 30))
+	if b.len() != 3 {
+		t.Fatal("Expected 3 messages, got", b.len())
+	}
+
+	// Over the limit: the messages of peer 1 for the highest heights go
+	b.put(4, 2, make([]byte, 30))
+	b.put(3, 0, make([]byte, 30))
+	if b.len() != 3 || len(b.msgs[5]) != 0 || len(b.msgs[4]) != 1 || b.peerBytes[1] != 30 {
+		t.Error("Flooding peer not evicted:", b.msgs)
+	}
+
+	msgs := b.take(4, 4)
+	if len(msgs) != 1 || msgs[0].peer != 2 {
+		t.Error("Wrong messages taken:", msgs)
+	}
+	if b.len() != 0 || b.bytes != 0 || len(b.peerBytes) != 0 {
+		t.Error("Messages below height 4 not dropped:", b.msgs, b.bytes)
+	}
+}
+
+// A validator cut off from the others while they finalize a few blocks
+// catches up through a sync once it hears from them again.
+func TestSyncLaggingValidator(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, 50*time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+	}
+	// The others can finalize blocks 1 to 3 without validator 0, whose turn to
+	// propose comes at height 4
+	lagging := 0
+	reference := vals[1]
+	store := &MemBlockStore{}
+	store.Init()
+	vals[lagging].store = store
+
+	round := func(isolated bool) {
+		for j := 0; j < numVals; j++ {
+			if !isolated || j != lagging {
+				vals[j].Send()
+			}
+		}
+		for delivered := true; delivered; {
+			delivered = false
+			for j := 0; j < numVals; j++ {
+				for len(vals[j].transport.Receive()) > 0 {
+					pkt := <-vals[j].transport.Receive()
+					delivered = true
+					if isolated && (j == lagging || pkt.Peer == lagging) {
+						continue
+					}
+					vals[j].handleMsgDataFrom(pkt.Peer, pkt.Data)
+				}
+			}
+		}
+	}
+
+	vals[getProposerID(1, numVals)].proposeBlock(1)
+	for r := 0; r < 200 && finalizedHeight(reference) < 3; r++ {
+		round(true)
+	}
+	if finalizedHeight(reference) < 3 || vals[lagging].blockHeight != 0 {
+		t.Fatal("Setup failed:", finalizedHeight(reference), vals[lagging].blockHeight)
+	}
+
+	target := finalizedHeight(reference) + 2
+	for r := 0; r < 200 && finalizedHeight(vals[lagging]) < target; r++ {
+		round(false)
+	}
+	if finalizedHeight(vals[lagging]) < target {
+		t.Fatal("Lagging validator did not catch up:", finalizedHeight(vals[lagging]), "<", target)
+	}
+
+	// The first block it stores is the one it synced to, with a valid
+	// certificate like the blocks it finalized itself
+	synced := uint64(1)
+	for ; synced < target; synced++ {
+		if _, err := store.Get(synced); err == nil {
+			break
+		}
+	}
+	for h := synced; h <= target; h++ {
+		cert, err := vals[lagging].Certificate(h)
+		if err != nil {
+			t.Fatal("Block", h, "not stored:", err)
+		}
+		if err := cert.Verify(vals[lagging].bls, vals[lagging].valPubKeySet, vals[lagging].chainID, h); err != nil {
+			t.Error("Invalid certificate for block", h, ":", err)
+		}
+	}
+	if block, _ := store.Get(synced); len(block.Data) == 0 {
+		t.Error("Synced block stored without its data.")
+	}
+}
+
+func TestSyncRequestLimits(t *testing.T) {
+	numVals := 4
+	vals := genValidators(numVals, 1, time.Millisecond, false)
+	network := &MemNetwork{}
+	network.Init()
+	for i := 0; i < numVals; i++ {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		vals[i].SetTransport(tr)
+	}
+	aggSig := &AggSig{}
+	aggSig.Init(vals[1].bls, numVals)
+	vals[1].stateMutex.Lock()
+	vals[1].cert = &syncCert{blockHeight: 3, hash: make([]byte, LenHash), aggSig: aggSig}
+	vals[1].stateMutex.Unlock()
+
+	// A peer asking repeatedly is answered once per SyncInterval
+	for i := 0; i < 3; i++ {
+		vals[0].lastSyncRequest = time.Time{}
+		vals[0].requestSync(1)
+	}
+	for len(vals[1].transport.Receive()) > 0 {
+		pkt := <-vals[1].transport.Receive()
+		vals[1].handleMsgDataFrom(pkt.Peer, pkt.Data)
+	}
+	if n := len(vals[0].transport.Receive()); n != 1 {
+		t.Fatal("Expected 1 SyncResponse, got", n)
+	}
+	resp := <-vals[0].transport.Receive()
+
+	// A response from a peer we did not ask is ignored before verifying
+	useless := vals[0].inboundCounters.useless
+	if vals[0].verifySyncResponse(2, resp.Data) != nil || vals[0].inboundCounters.useless != useless+1 {
+		t.Error("Unrequested SyncResponse accepted.")
+	}
+}
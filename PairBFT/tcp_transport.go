@@ -0,0 +1,307 @@
+package PairBFT
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// TCPTransport keeps one persistent connection per peer. Every frame is
+// prefixed with its length; the first frame on a dialed connection carries
+// the index of the dialing validator. Writes time out, so a peer that stops
+// reading cannot block the sender, and at most MaxInboundConns accepted
+// connections are served at a time.
+type (
+	TCPTransport struct {
+		id       int
+		book     *AddrBook
+		listener net.Listener
+		dial     func(peer int, addr string) (net.Conn, error)
+		recv     chan Packet
+
+		// authenticate, if set, checks that an accepted connection belongs to the announced peer
+		authenticate func(conn net.Conn, peer int) bool
+
+		connMutex sync.Mutex
+		conns     map[int]*tcpConn
+		inbound   map[*tcpConn]bool
+
+		closeOnce sync.Once
+		closed    chan struct{}
+		wg        sync.WaitGroup
+	}
+
+	tcpConn struct {
+		conn       net.Conn
+		writeMutex sync.Mutex
+	}
+)
+
+const (
+	lenFrameHeader  = 4
+	tcpDialTimeout  = 2 * time.Second
+	tcpWriteTimeout = 5 * time.Second
+	MaxInboundConns = 256
+)
+
+func (t *TCPTransport) Init(id int, book *AddrBook) error {
+	listener, err := net.Listen("tcp", book.Addr(id))
+	if err != nil {
+		return err
+	}
+	dial := func(peer int, addr string) (net.Conn, error) {
+		return net.DialTimeout("tcp", addr, tcpDialTimeout)
+	}
+	t.init(id, book, listener, dial)
+	return nil
+}
+
+func (t *TCPTransport) init(id int, book *AddrBook, listener net.Listener, dial func(int, string) (net.Conn, error)) {
+	t.id = id
+	t.book = book
+	t.listener = listener
+	t.dial = dial
+	t.recv = make(chan Packet, TransportQueueSize)
+	t.conns = make(map[int]*tcpConn)
+	t.inbound = make(map[*tcpConn]bool)
+	t.closed = make(chan struct{})
+	t.wg.Add(1)
+	go t.acceptLoop()
+}
+
+func (t *TCPTransport) Send(peer int, data []byte) error {
+	if len(data) > MaxMsgSize {
+		return ErrMsgTooLarge
+	}
+	c, err := t.getConn(peer)
+	if err != nil {
+		return err
+	}
+	if err = c.writeFrame(data); err != nil {
+		// The peer may have restarted; retry once on a fresh connection
+		t.dropConn(peer, c)
+		if c, err = t.getConn(peer); err != nil {
+			return err
+		}
+		if err = c.writeFrame(data); err != nil {
+			t.dropConn(peer, c)
+		}
+	}
+	return err
+}
+
+func (t *TCPTransport) Receive() <-chan Packet {
+	return t.recv
+}
+
+func (t *TCPTransport) Close() error {
+	err := ErrTransportClosed
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.listener.Close()
+		t.connMutex.Lock()
+		for peer, c := range t.conns {
+			c.conn.Close()
+			delete(t.conns, peer)
+		}
+		for c := range t.inbound {
+			c.conn.Close()
+			delete(t.inbound, c)
+		}
+		t.connMutex.Unlock()
+		go func() {
+			t.wg.Wait()
+			close(t.recv)
+		}()
+	})
+	return err
+}
+
+func (t *TCPTransport) getConn(peer int) (*tcpConn, error) {
+	t.connMutex.Lock()
+	select {
+	case <-t.closed:
+		t.connMutex.Unlock()
+		return nil, ErrTransportClosed
+	default:
+	}
+	c, ok := t.conns[peer]
+	t.connMutex.Unlock()
+	if ok {
+		return c, nil
+	}
+
+	// Dial without the lock, so that an unreachable peer does not hold up
+	// the sends to the others
+	addr := t.book.Addr(peer)
+	if addr == "" {
+		return nil, ErrUnknownPeer
+	}
+	conn, err := t.dial(peer, addr)
+	if err != nil {
+		return nil, err
+	}
+	c = &tcpConn{conn: conn}
+	hello := make([]byte, 4)
+	binary.LittleEndian.PutUint32(hello, uint32(int32(t.id)))
+	if err = c.writeFrame(hello); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	t.connMutex.Lock()
+	defer t.connMutex.Unlock()
+	select {
+	case <-t.closed:
+		conn.Close()
+		return nil, ErrTransportClosed
+	default:
+	}
+	// Another send may have connected meanwhile
+	if other, ok := t.conns[peer]; ok {
+		conn.Close()
+		return other, nil
+	}
+	t.conns[peer] = c
+	t.wg.Add(1)
+	go t.readLoop(c, peer)
+	return c, nil
+}
+
+func (t *TCPTransport) dropConn(peer int, c *tcpConn) {
+	t.connMutex.Lock()
+	if t.conns[peer] == c {
+		delete(t.conns, peer)
+	}
+	t.connMutex.Unlock()
+	c.conn.Close()
+}
+
+func (t *TCPTransport) acceptLoop() {
+	defer t.wg.Done()
+	for {
+		conn, err := t.listener.Accept()
+		if err != nil {
+			select {
+			case <-t.closed:
+				return
+			default:
+			}
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				continue
+			}
+			return
+		}
+		t.wg.Add(1)
+		go t.serveConn(conn)
+	}
+}
+
+func (t *TCPTransport) serveConn(conn net.Conn) {
+	c := &tcpConn{conn: conn}
+	t.connMutex.Lock()
+	select {
+	case <-t.closed:
+		t.connMutex.Unlock()
+		conn.Close()
+		t.wg.Done()
+		return
+	default:
+	}
+	if len(t.inbound) >= MaxInboundConns {
+		t.connMutex.Unlock()
+		conn.Close()
+		t.wg.Done()
+		return
+	}
+	t.inbound[c] = true
+	t.connMutex.Unlock()
+	defer func() {
+		t.connMutex.Lock()
+		delete(t.inbound, c)
+		t.connMutex.Unlock()
+	}()
+
+	r := bufio.NewReader(conn)
+	conn.SetReadDeadline(time.Now().Add(tcpDialTimeout))
+	hello, err := readFrame(r)
+	conn.SetReadDeadline(time.Time{})
+	if err != nil || len(hello) != 4 {
+		conn.Close()
+		t.wg.Done()
+		return
+	}
+	peer := int(int32(binary.LittleEndian.Uint32(hello)))
+	if peer < 0 {
+		conn.Close()
+		t.wg.Done()
+		return
+	}
+	if peer >= t.book.Len() {
+		peer = -1
+	}
+	if t.authenticate != nil && !t.authenticate(conn, peer) {
+		conn.Close()
+		t.wg.Done()
+		return
+	}
+	t.readFrames(c, r, peer)
+}
+
+func (t *TCPTransport) readLoop(c *tcpConn, peer int) {
+	t.readFrames(c, bufio.NewReader(c.conn), peer)
+}
+
+func (t *TCPTransport) readFrames(c *tcpConn, r *bufio.Reader, peer int) {
+	defer t.wg.Done()
+	defer c.conn.Close()
+
+	addr := c.conn.RemoteAddr().String()
+	for {
+		data, err := readFrame(r)
+		if err != nil {
+			return
+		}
+		select {
+		case t.recv <- Packet{Peer: peer, Addr: addr, Data: data}:
+		case <-t.closed:
+			return
+		}
+	}
+}
+
+func (c *tcpConn) writeFrame(data []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	b := make([]byte, lenFrameHeader+len(data))
+	binary.LittleEndian.PutUint32(b, uint32(len(data)))
+	copy(b[lenFrameHeader:], data)
+	c.conn.SetWriteDeadline(time.Now().Add(tcpWriteTimeout))
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func readFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, lenFrameHeader)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	l := binary.LittleEndian.Uint32(header)
+	if l > MaxMsgSize {
+		return nil, ErrMsgTooLarge
+	}
+	// The buffer grows as the data arrives, not as the header claims
+	var buf bytes.Buffer
+	if _, err := io.CopyN(&buf, r, int64(l)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,26 @@
+package PairBFT
+
+import (
+	"errors"
+)
+
+var (
+	ErrTransportClosed = errors.New("transport closed")
+	ErrUnknownPeer     = errors.New("unknown peer")
+	ErrMsgTooLarge     = errors.New("message too large")
+	ErrQueueFull       = errors.New("receive queue full")
+)
+
+type (
+	Packet struct {
+		Peer int // index of the sending validator, -1 if unknown
+		Addr string
+		Data []byte
+	}
+
+	Transport interface {
+		Send(peer int, data []byte) error
+		Receive() <-chan Packet
+		Close() error
+	}
+)
@@ -0,0 +1,136 @@
+package PairBFT
+
+import (
+	"bytes"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func checkTransports(t *testing.T, transports []Transport) {
+	defer func() {
+		for _, tr := range transports {
+			tr.Close()
+		}
+	}()
+
+	small := []byte{MsgTypePrepare, 1, 2, 3}
+	large := make([]byte, 3*MaxPacketSize)
+	for i := range large {
+		large[i] = byte(i)
+	}
+
+	for _, data := range [][]byte{small, large} {
+		for round := 0; round < 2; round++ {
+			if err := transports[0].Send(1, data); err != nil {
+				t.Fatal("Send failed: ", err)
+			}
+			select {
+			case pkt := <-transports[1].Receive():
+				if !bytes.Equal(pkt.Data, data) {
+					t.Error("Received data differs from sent data.")
+				}
+				if pkt.Peer != 0 {
+					t.Error("Wrong sender: ", pkt.Peer)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("Timed out waiting for packet.")
+			}
+		}
+	}
+}
+
+func genTestAddrBook(numVals int, startPort int) *AddrBook {
+	addrs := make([]string, numVals)
+	for i := 0; i < numVals; i++ {
+		addrs[i] = "127.0.0.1:" + strconv.Itoa(startPort+i)
+	}
+	book := &AddrBook{}
+	book.Init(addrs)
+	return book
+}
+
+func TestUDPTransport(t *testing.T) {
+	book := genTestAddrBook(2, 2100)
+	transports := make([]Transport, 2)
+	for i := range transports {
+		tr := &UDPTransport{}
+		if err := tr.Init(i, book); err != nil {
+			t.Fatal(err)
+		}
+		transports[i] = tr
+	}
+	checkTransports(t, transports)
+}
+
+func TestTCPTransport(t *testing.T) {
+	book := genTestAddrBook(2, 2110)
+	transports := make([]Transport, 2)
+	for i := range transports {
+		tr := &TCPTransport{}
+		if err := tr.Init(i, book); err != nil {
+			t.Fatal(err)
+		}
+		transports[i] = tr
+	}
+	checkTransports(t, transports)
+}
+
+func TestMemTransport(t *testing.T) {
+	network := &MemNetwork{}
+	network.Init()
+	transports := make([]Transport, 2)
+	for i := range transports {
+		tr := &MemTransport{}
+		tr.Init(i, network)
+		transports[i] = tr
+	}
+	checkTransports(t, transports)
+}
+
+func TestTCPTransportSlowDial(t *testing.T) {
+	book := genTestAddrBook(3, 2140)
+	transports := make([]*TCPTransport, 2)
+	for i := range transports {
+		transports[i] = &TCPTransport{}
+		if err := transports[i].Init(i, book); err != nil {
+			t.Fatal(err)
+		}
+		defer transports[i].Close()
+	}
+
+	// Dialing peer 2 hangs; sends to peer 1 must not wait for it
+	unblock := make(chan struct{})
+	dial := transports[0].dial
+	transports[0].dial = func(peer int, addr string) (net.Conn, error) {
+		if peer == 2 {
+			<-unblock
+			return nil, ErrUnknownPeer
+		}
+		return dial(peer, addr)
+	}
+	go transports[0].Send(2, []byte{MsgTypePrepare})
+	time.Sleep(50 * time.Millisecond)
+	if err := transports[0].Send(1, []byte{MsgTypePrepare}); err != nil {
+		t.Fatal("Send failed: ", err)
+	}
+	select {
+	case <-transports[1].Receive():
+	case <-time.After(time.Second):
+		t.Error("A hanging dial blocked sends to other peers.")
+	}
+	close(unblock)
+}
+
+func TestReadFrameShortData(t *testing.T) {
+	// The header claims far more data than the peer sends
+	frame := []byte{0, 0, 0x10, 0, 1, 2, 3}
+	if _, err := readFrame(bytes.NewReader(frame)); err == nil {
+		t.Error("Truncated frame accepted.")
+	}
+	frame = []byte{3, 0, 0, 0, 1, 2, 3}
+	if data, err := readFrame(bytes.NewReader(frame)); err != nil || !bytes.Equal(data, []byte{1, 2, 3}) {
+		t.Error("Frame not read: ", data, err)
+	}
+}
@@ -0,0 +1,110 @@
+package PairBFT
+
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// UDPTransport sends and receives all datagrams through a single socket bound
+// to the validator's own address. Messages longer than MaxPacketSize are
+// fragmented.
+type (
+	UDPTransport struct {
+		id          int
+		book        *AddrBook
+		conn        net.PacketConn
+		recv        chan Packet
+		reassembler Reassembler
+		fragmentID  uint64
+		closeOnce   sync.Once
+		closed      chan struct{}
+	}
+)
+
+func (t *UDPTransport) Init(id int, book *AddrBook) error {
+	conn, err := net.ListenPacket("udp", book.Addr(id))
+	if err != nil {
+		return err
+	}
+	t.id = id
+	t.book = book
+	t.conn = conn
+	t.recv = make(chan Packet, TransportQueueSize)
+	t.closed = make(chan struct{})
+	t.reassembler.Init(FragmentTimeout, MaxReassemblyMemory)
+	t.fragmentID = rand.Uint64()
+	go t.readLoop()
+	return nil
+}
+
+func (t *UDPTransport) Send(peer int, data []byte) error {
+	if len(data) > MaxMsgSize {
+		return ErrMsgTooLarge
+	}
+	addr, err := net.ResolveUDPAddr("udp", t.book.Addr(peer))
+	if err != nil {
+		return err
+	}
+	for _, packet := range fragmentData(data, atomic.AddUint64(&t.fragmentID, 1)) {
+		if _, err := t.conn.WriteTo(packet, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *UDPTransport) Receive() <-chan Packet {
+	return t.recv
+}
+
+func (t *UDPTransport) Close() error {
+	err := ErrTransportClosed
+	t.closeOnce.Do(func() {
+		close(t.closed)
+		err = t.conn.Close()
+	})
+	return err
+}
+
+func (t *UDPTransport) readLoop() {
+	defer close(t.recv)
+
+	buffer := make([]byte, MaxPacketSize)
+	for {
+		n, addr, err := t.conn.ReadFrom(buffer)
+		if err != nil {
+			select {
+			case <-t.closed:
+				return
+			default:
+			}
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				continue
+			}
+			return
+		}
+		if n == 0 {
+			continue
+		}
+
+		var data []byte
+		if buffer[0] == MsgTypeFragment {
+			data = t.reassembler.Add(addr.String(), buffer[:n], time.Now())
+			if data == nil {
+				continue
+			}
+		} else {
+			data = make([]byte, n)
+			copy(data, buffer[:n])
+		}
+
+		select {
+		case t.recv <- Packet{Peer: t.book.Index(addr.String()), Addr: addr.String(), Data: data}:
+		case <-t.closed:
+			return
+		}
+	}
+}
@@ -3,12 +3,16 @@ package PairBFT
 import (
 	"time"
 	"github.com/Nik-U/pbc"
-	"net"
 	"sync"
 	"github.com/sirupsen/logrus"
-	"path/filepath"
 	"strconv"
 	"os"
+	"crypto/ed25519"
+	"errors"
+	"context"
+	"encoding/hex"
+	"sync/atomic"
+	"fmt"
 )
 
 // todo: change this to real block data
@@ -23,61 +27,129 @@ type (
 		id                           int
 		blockHeight                  uint64
 		state                        int
-		branchFactor                 int
-		epochLen                     time.Duration
+		branchFactor                 int           // guarded by gossipMutex
+		epochLen                     time.Duration // guarded by gossipMutex
 		hash, blockData              []byte
 		aggSig, prevAggSig           *AggSig
+		aggPool                      AggPool
+		singles                      sigStore // individual signatures, for localizeFault
 		stateMutex                   sync.Mutex
 		pPairer, cPairer, prevPairer *pbc.Pairer
+		hashVersion                  uint64 // incremented whenever the pairers change
 		peerHeight                   uint64
-		prevHash, prevBlockData      []byte // for CommitPrepare
+		pending                      pendingBuffer
+		cert                         *syncCert // of the last finalized block
+		lastSyncRequest              time.Time
+		syncPeer                     int               // asked by the last sync request
+		syncHeight                   uint64            // our certificate height when asking
+		syncServed                   map[int]time.Time // last sync request answered, by peer
+		prevHash, prevBlockData      []byte            // for CommitPrepare
 
 		PubKey, privKey *pbc.Element
 		PubKeySig       *pbc.Element
 
-		log *logrus.Logger
-
-		valAddrSet   []string
-		valPubKeySet []*pbc.Element
-
-		debugEpochLimit int
-		debugTerminated chan bool
+		NodeKey     ed25519.PublicKey // authenticates peer links, see SecureTransport
+		NodeKeySig  *pbc.Element
+		nodePrivKey ed25519.PrivateKey
+
+		log    *logrus.Logger
+		events eventLog
+
+		valAddrSet    []string
+		valPubKeySet  []*pbc.Element
+		valNodeKeySet []ed25519.PublicKey
+		aggKeys       AggKeyCache
+		valSetHash    []byte
+		observer      bool     // see observer.go
+		observerAddrs []string // peers after the validator set
+		chainID       string
+
+		faultMutex   sync.Mutex
+		faultReports []FaultReport
+
+		addrBook     AddrBook
+		addrBookFile string
+		transport    Transport
+		store        BlockStore
+		blocks       blockFeed
+		sendEpoch    uint64
+		peerSelector PeerSelector
+		gossipMode   int // guarded by gossipMutex
+		gossipMutex  sync.Mutex
+		replyMutex   sync.Mutex
+		lastReply    map[int]uint64 // send epoch of the last push-pull reply to each peer
+
+		dissemination int
+		schedule      *GossipSchedule
+		progress      chan bool
+
+		bandwidth    *TokenBucket
+		sendCounters sendCounters
+
+		inboundFilter   InboundFilter
+		inboundCounters inboundCounters
+		numWorkers      int
+
+		metrics       metrics
+		metricsAddr   string
+		rpcAddr       string
+		publicRPCAddr string
+
+		runMutex sync.Mutex // guards cancel and done
+		cancel   context.CancelFunc
+		done     chan struct{}
+		errOnce  sync.Once
+		err      error
+
+		debugEpochLimit int // stop after this many sends, 0 for no limit
 	}
 )
 
-func (val *Validator) initLog() { // requires val.id
-	val.log = logrus.New()
-	val.log.SetLevel(logLevel)
-	fileName, _ := filepath.Abs("log/validator" + strconv.Itoa(val.id) + ".log")
-
-	if _, err := os.Stat(fileName); err == nil {
-		os.Remove(fileName)
-	}
+var (
+	ErrValidatorStarted = errors.New("validator already started")
+	ErrNotFirstProposer = errors.New("not the proposer of block 1")
+	ErrObserverAddrs    = errors.New("observers do not announce addresses")
+)
 
-	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_WRONLY, 0666)
-	if err == nil {
-		val.log.Out = file
-	} else {
-		val.log.Info("Failed to log to file, using default stdout")
-	}
+// Init sets up a validator with a fresh key; SetValSet must be called
+// afterwards. NewValidator does both from a validated Config. The validator
+// logs to stderr at InfoLevel, see SetLogOutput and SetLogLevel.
+func (val *Validator) Init(id int, bls *BLS, bf int, epochLen time.Duration, useCommitPrepare bool) {
+	val.id = id
+	val.log = logrus.New()
+	privKey, _ := bls.GenKey()
+	_, nodeKey := GenNodeKey()
+	val.init(id, bls, privKey, nodeKey, useCommitPrepare)
+	val.branchFactor = bf
+	val.epochLen = epochLen
 }
 
-func (val *Validator) Init(id int, bls *BLS, bf int, epochLen time.Duration, useCommitPrepare bool) {
-	val.debugTerminated = make(chan bool)
+func (val *Validator) init(id int, bls *BLS, privKey *pbc.Element, nodeKey ed25519.PrivateKey, useCommitPrepare bool) {
+	val.progress = make(chan bool, 1)
+	val.lastReply = make(map[int]uint64)
+	val.syncServed = make(map[int]time.Time)
+	val.inboundFilter.Init(SourcePacketRate, SourcePacketBurst, DupCacheSize)
+	val.pending.init(MaxPendingBytes)
+	val.metrics.init()
 
 	val.bls = bls
 	val.useCommitPrepare = useCommitPrepare
 	val.id = id
 	val.blockHeight = 0
 	val.state = StateIdle
-	val.branchFactor = bf
-	val.epochLen = epochLen
-
-	val.privKey, val.PubKey = bls.GenKey()
-	h := getNoncedHash(val.PubKey.Bytes(), NoncePubKey)
-	val.PubKeySig = val.bls.SignHash(h, val.privKey)
-
-	val.initLog()
+	val.peerSelector = &RandomSelector{}
+
+	val.nodePrivKey = nodeKey
+	val.NodeKey = nodeKey.Public().(ed25519.PublicKey)
+	// Observers have no signing key
+	if privKey != nil {
+		val.privKey = privKey
+		val.PubKey = bls.pairing.NewG2().PowZn(bls.g, privKey)
+		h := getNoncedHash(val.PubKey.Bytes(), NoncePubKey)
+		val.PubKeySig = val.bls.SignHash(h, val.privKey)
+		h = getNoncedHash(val.NodeKey, NonceNodeKey)
+		val.NodeKeySig = val.bls.SignHash(h, val.privKey)
+	}
 
 	// todo: change this to real block data
 	val.blockData = []byte(MockBlockDataString)
@@ -87,59 +159,280 @@ func (val *Validator) Init(id int, bls *BLS, bf int, epochLen time.Duration, use
 
 	val.log.Print("BLS params: ", bls.params)
 	val.log.Print("BLS g: ", bls.g)
-	val.log.Print("Public key: ", val.PubKey)
-	val.log.Debug("Private key: ", val.privKey)
+	if privKey != nil {
+		val.log.Print("Public key: ", val.PubKey)
+		val.log.Debug("Private key: ", val.privKey)
+	}
 }
 
-func (val *Validator) SetValSet(valAddrSet []string, valPubKeySet []*pbc.Element, valPubKeySig []*pbc.Element) {
+// SetValSet sets the validator set after checking the proof of possession of
+// each public key, as Config.Validate does.
+func (val *Validator) SetValSet(valAddrSet []string, valPubKeySet []*pbc.Element, valPubKeySig []*pbc.Element) error {
+	numVals := len(valAddrSet)
+	if len(valPubKeySet) != numVals || len(valPubKeySig) != numVals {
+		return errors.New("public key set does not match validator set")
+	}
+	for i := 0; i < numVals; i++ {
+		h := getNoncedHash(valPubKeySet[i].Bytes(), NoncePubKey)
+		if !val.bls.VerifyHash(h, valPubKeySig[i], valPubKeySet[i]) {
+			return fmt.Errorf("invalid proof of possession of validator %d", i)
+		}
+	}
+	val.setValSet(valAddrSet, valPubKeySet)
+	return nil
+}
+
+func (val *Validator) setValSet(valAddrSet []string, valPubKeySet []*pbc.Element) {
 	val.valAddrSet = valAddrSet
 	val.valPubKeySet = valPubKeySet
-	numVals := len(val.valAddrSet)
+	val.valSetHash = ValSetHash(valPubKeySet)
+	val.addrBook.Init(append(append([]string(nil), valAddrSet...), val.observerAddrs...))
+	val.aggKeys.Init(val.bls, valPubKeySet, AggKeyCacheSize)
+	val.aggPool.Init(val.bls, len(valAddrSet), AggPoolSize, MaxSigMultiplicity)
+}
+
+// SetAddrs announces new addresses for this validator. The signed record
+// replaces the old one in the address books of the other validators as it is
+// gossiped. Observers have no key to sign the record with.
+func (val *Validator) SetAddrs(addrs []string) error {
+	if val.observer {
+		return ErrObserverAddrs
+	}
+	rec := &AddrRecord{
+		ValIndex: uint32(val.id),
+		Addrs:    addrs,
+	}
+	// Sequence numbers are timestamps, so they keep increasing across restarts
+	rec.Seq = uint64(time.Now().UnixNano())
+	if old := val.addrBook.Record(val.id); old != nil && rec.Seq <= old.Seq {
+		rec.Seq = old.Seq + 1
+	}
+	rec.Sign(val.bls, val.privKey)
+	if !val.addrBook.Update(rec, val.bls, val.valPubKeySet) {
+		return ErrInvalidAddrRecord
+	}
+	val.saveAddrBook()
+	return nil
+}
+
+// SetAddrBookFile loads the last known addresses from fileName, if it exists,
+// and saves the address book there whenever it changes.
+func (val *Validator) SetAddrBookFile(fileName string) error {
+	val.addrBookFile = fileName
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		return nil
+	}
+	return val.addrBook.Load(fileName, val.bls, val.valPubKeySet)
+}
+
+func (val *Validator) saveAddrBook() {
+	if val.addrBookFile == "" {
+		return
+	}
+	if err := val.addrBook.Save(val.addrBookFile); err != nil {
+		val.log.Error("Failed to save address book: ", err)
+	}
+}
+
+// SetNodeKeys records the node keys of the validator set. Each key must be
+// signed with the BLS key of its validator.
+func (val *Validator) SetNodeKeys(valNodeKeySet []ed25519.PublicKey, valNodeKeySig []*pbc.Element) error {
+	numVals := len(val.valPubKeySet)
+	if len(valNodeKeySet) != numVals || len(valNodeKeySig) != numVals {
+		return errors.New("node key set does not match validator set")
+	}
 	for i := 0; i < numVals; i++ {
-		h := getNoncedHash(valPubKeySet[i].Bytes(), NoncePubKey)
-		val.bls.VerifyHash(h, valPubKeySig[i], valPubKeySet[i])
+		h := getNoncedHash(valNodeKeySet[i], NonceNodeKey)
+		if !val.bls.VerifyHash(h, valNodeKeySig[i], val.valPubKeySet[i]) {
+			return errors.New("invalid node key signature of validator " + strconv.Itoa(i))
+		}
 	}
+	val.valNodeKeySet = valNodeKeySet
+	return nil
 }
 
-func (val *Validator) Listen() {
-	pc, err := net.ListenPacket("udp", val.valAddrSet[val.id])
+// UseSecureTransport opens an encrypted transport whose peers are pinned to the
+// node keys given to SetNodeKeys.
+func (val *Validator) UseSecureTransport() error {
+	if val.valNodeKeySet == nil {
+		return errors.New("node keys of the validator set are unknown")
+	}
+	t := &SecureTransport{}
+	if err := t.Init(val.id, &val.addrBook, val.nodePrivKey, val.valNodeKeySet); err != nil {
+		return err
+	}
+	val.transport = t
+	return nil
+}
+
+// SetGossipMode selects GossipPush or GossipPushPull.
+func (val *Validator) SetGossipMode(mode int) {
+	val.gossipMutex.Lock()
+	defer val.gossipMutex.Unlock()
+	val.gossipMode = mode
+}
+
+func (val *Validator) SetPeerSelector(selector PeerSelector) {
+	val.peerSelector = selector
+}
+
+// SetDissemination selects DisseminateGossip, DisseminateStar or
+// DisseminateTree.
+func (val *Validator) SetDissemination(mode int) {
+	val.dissemination = mode
+}
+
+// SetTransport selects how the validator talks to its peers. It must be called
+// before Start; by default a UDPTransport is opened on the validator's address.
+func (val *Validator) SetTransport(transport Transport) {
+	val.transport = transport
+}
+
+func (val *Validator) openTransport() error {
+	if val.transport != nil {
+		return nil
+	}
+	t := &UDPTransport{}
+	if err := t.Init(val.id, &val.addrBook); err != nil {
+		return err
+	}
+	val.transport = t
+	return nil
+}
+
+// Start opens the transport and runs the validator in the background until
+// ctx is done or Stop is called. A validator runs only once: once it has
+// stopped, Start fails with ErrValidatorStarted, and a new validator must be
+// created to run again.
+func (val *Validator) Start(ctx context.Context) error {
+	val.runMutex.Lock()
+	defer val.runMutex.Unlock()
+	if val.done != nil {
+		return ErrValidatorStarted
+	}
+	endpoints, err := val.listenHTTP()
 	if err != nil {
-		val.log.Panic("Error listening to UDP address: ", err)
-	}
-	defer pc.Close()
-
-	buffer := make([]byte, MaxPacketSize)
-	for stop := false; !stop; {
-		pc.SetDeadline(time.Now().Add(val.epochLen))
-		n, _, err := pc.ReadFrom(buffer)
-		if err != nil {
-			if e, ok := err.(net.Error); !ok || !e.Timeout() {
-				val.log.Panic("Error reading UDP packet: ", err)
-			}
-		} else {
-			val.handleMsgData(buffer[:n])
+		return err
+	}
+	if err := val.openTransport(); err != nil {
+		for _, e := range endpoints {
+			e.listener.Close()
 		}
-		select {
-		case stop = <-val.debugTerminated:
-		default:
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	val.cancel = cancel
+	val.done = done
+
+	for _, e := range endpoints {
+		go val.serveHTTP(ctx, e)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if err := val.Listen(ctx); err != nil {
+			val.setErr(err)
+		}
+		cancel()
+	}()
+	go func() {
+		defer wg.Done()
+		val.runSender(ctx)
+		cancel()
+	}()
+	go func() {
+		wg.Wait()
+		if err := val.transport.Close(); err != nil && err != ErrTransportClosed {
+			val.setErr(err)
 		}
+		val.blocks.close()
+		val.log.Print("Stopped.")
+		close(done)
+	}()
+	return nil
+}
+
+// ProposeFirstBlock starts the chain. No validator proposes block 1 on its
+// own: its proposer calls this once, typically before Start.
+func (val *Validator) ProposeFirstBlock() error {
+	val.stateMutex.Lock()
+	defer val.stateMutex.Unlock()
+	if val.state != StateIdle || val.blockHeight != 0 || getProposerID(1, len(val.valAddrSet)) != val.id {
+		return ErrNotFirstProposer
+	}
+	if val.useCommitPrepare {
+		val.commitProposeBlock(1)
+	} else {
+		val.proposeBlock(1)
 	}
+	return nil
 }
 
-func (val *Validator) Start() {
-	go val.Listen()
+// Stop shuts the validator down and waits until it has stopped.
+func (val *Validator) Stop() error {
+	val.runMutex.Lock()
+	cancel := val.cancel
+	val.runMutex.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	return val.Wait()
+}
 
-	for epoch := 0; val.debugEpochLimit != 0 && epoch < val.debugEpochLimit; epoch++ {
-		go val.Send()
-		time.Sleep(val.epochLen)
+// Wait blocks until the validator has stopped, and returns the error that
+// stopped it, if any.
+func (val *Validator) Wait() error {
+	val.runMutex.Lock()
+	done := val.done
+	val.runMutex.Unlock()
+	if done == nil {
+		return nil
+	}
+	<-done
+	return val.err
+}
+
+func (val *Validator) setErr(err error) {
+	val.errOnce.Do(func() {
+		val.err = err
+		val.log.Error("Stopping: ", err)
+	})
+}
+
+func (val *Validator) runSender(ctx context.Context) {
+	if val.schedule != nil {
+		val.runSchedule(ctx)
+		return
+	}
+
+	var sends sync.WaitGroup
+	defer sends.Wait()
+	for epoch := 0; val.debugEpochLimit == 0 || epoch < val.debugEpochLimit; epoch++ {
+		sends.Add(1)
+		go func() {
+			defer sends.Done()
+			val.Send()
+		}()
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(val.GossipParams().EpochLen):
+		}
 	}
-	val.debugTerminated <- true
 }
 
 func (val *Validator) InitAggSig() {
 	numVals := len(val.valAddrSet)
 	val.aggSig = &AggSig{}
 	val.aggSig.Init(val.bls, numVals)
+	if val.observer {
+		val.aggSig.sig.Set1()
+		val.aggPool.Reset()
+		return
+	}
 	val.aggSig.counters[val.id] = 1
 
 	nounce := NoncePrepare
@@ -151,10 +444,20 @@ func (val *Validator) InitAggSig() {
 	}
 	h := getNoncedHash(val.hash, nounce)
 	val.aggSig.sig = val.bls.SignHash(h, val.privKey)
+	val.aggPool.Reset()
+	val.aggPool.AddSingle(val.id, val.aggSig.sig)
+	if nounce == NonceCommit {
+		val.singles.add(val.cPairer, val.id, val.aggSig.sig)
+	} else {
+		val.singles.add(val.pPairer, val.id, val.aggSig.sig)
+	}
 }
 
 func (val *Validator) updateHash(hash []byte) {
+	val.prevHash = val.hash
 	val.hash = hash
+	val.hashVersion++
+	val.metrics.seeBlock()
 	if val.useCommitPrepare {
 		val.prevPairer = val.pPairer
 		val.pPairer = val.bls.PreprocessHash(getNoncedHash(hash, NonceCommitPrepare))
@@ -164,6 +467,7 @@ func (val *Validator) updateHash(hash []byte) {
 		cHash := getNoncedHash(hash, NonceCommit)
 		val.cPairer = val.bls.PreprocessHash(cHash)
 	}
+	val.singles.retain(val.pPairer, val.cPairer, val.prevPairer)
 }
 
 func (val *Validator) proposeBlock(blockHeight uint64) {
@@ -173,7 +477,7 @@ func (val *Validator) proposeBlock(blockHeight uint64) {
 	val.updateHash(h)
 	val.prevAggSig = val.aggSig
 	val.InitAggSig()
-	val.log.Print("Propose@", val.blockHeight, "#", val.hash)
+	val.transition("Propose", val.blockHeight, val.hash, val.aggSig)
 }
 
 func (val *Validator) prepareBlock(blockHeight uint64, hash []byte, aggSig *AggSig, prevAggSig *AggSig) {
@@ -182,8 +486,8 @@ func (val *Validator) prepareBlock(blockHeight uint64, hash []byte, aggSig *AggS
 	val.updateHash(hash)
 	val.prevAggSig = prevAggSig
 	val.InitAggSig()
-	val.aggSig.Aggregate(aggSig)
-	val.log.Print("Prepared@", val.blockHeight, ":", val.aggSig.counters)
+	val.aggregate(aggSig)
+	val.transition("Prepared", val.blockHeight, val.hash, val.aggSig)
 }
 
 func (val *Validator) commitBlock(blockHeight uint64, hash []byte, aggSig *AggSig, prevAggSig *AggSig) {
@@ -192,18 +496,30 @@ func (val *Validator) commitBlock(blockHeight uint64, hash []byte, aggSig *AggSi
 		val.updateHash(hash)
 	}
 	val.state = StateCommitted
+	observeSince(&val.metrics.commitTime, val.metrics.blockSeen)
 	val.prevAggSig = prevAggSig
 	val.InitAggSig()
 	if aggSig != nil {
-		val.aggSig.Aggregate(aggSig)
+		val.aggregate(aggSig)
 	}
-	val.log.Print("Committed@", val.blockHeight, ":", val.prevAggSig.counters)
+	val.transition("Committed", val.blockHeight, val.hash, val.prevAggSig)
 }
 
 func (val *Validator) finalizeBlock() {
 	val.state = StateFinal
-	val.log.Print("Finalized@", val.blockHeight, ":", val.aggSig.counters)
-	// Todo: add block to local blockchain. Slash the proposer if that fails.
+	val.recordCert()
+	observeSince(&val.metrics.finalizeTime, val.metrics.blockSeen)
+	atomic.AddUint64(&val.metrics.finalized, 1)
+	val.transition("Finalized", val.blockHeight, val.hash, val.aggSig)
+	// Todo: slash the proposer if the block is invalid.
+	val.storeBlock(&FinalizedBlock{
+		Height:     val.blockHeight,
+		Hash:       val.hash,
+		Data:       val.blockData,
+		AggSig:     val.aggSig,
+		Phase:      MsgTypeCommit,
+		ValSetHash: val.valSetHash,
+	})
 }
 
 func (val *Validator) commitProposeBlock(blockHeight uint64) {
@@ -213,7 +529,7 @@ func (val *Validator) commitProposeBlock(blockHeight uint64) {
 	val.updateHash(h)
 	val.prevAggSig = val.aggSig
 	val.InitAggSig()
-	val.log.Print("CommitPropose@", val.blockHeight, "#", val.hash)
+	val.transition("CommitPropose", val.blockHeight, val.hash, val.aggSig)
 }
 
 func (val *Validator) commitPrepareBlock(blockHeight uint64, hash []byte, aggSig *AggSig, prevAggSig *AggSig) {
@@ -222,32 +538,51 @@ func (val *Validator) commitPrepareBlock(blockHeight uint64, hash []byte, aggSig
 	val.updateHash(hash)
 	val.prevAggSig = prevAggSig
 	val.InitAggSig()
-	val.aggSig.Aggregate(aggSig)
-	val.log.Print("CommitPrepared@", val.blockHeight, ":", val.aggSig.counters)
+	val.aggregate(aggSig)
+	val.transition("CommitPrepared", val.blockHeight, val.hash, val.aggSig)
 }
 
 func (val *Validator) finalizePrevBlock() {
 	val.state = StateFinalPrepared
+	val.recordCert()
 	if val.blockHeight == 1 {
 		return
 	}
-	val.log.Print("Finalized@", val.blockHeight-1, ":", val.aggSig.counters)
-	// Todo: add block to local blockchain. Slash the proposer if that fails.
+	observeSince(&val.metrics.finalizeTime, val.metrics.prevBlockSeen)
+	atomic.AddUint64(&val.metrics.finalized, 1)
+	val.transition("Finalized", val.blockHeight-1, val.prevHash, val.aggSig)
+	// Todo: slash the proposer if the block is invalid.
+	val.storeBlock(&FinalizedBlock{
+		Height:     val.blockHeight - 1,
+		Hash:       val.prevHash,
+		Data:       val.prevBlockData,
+		AggSig:     val.aggSig,
+		ChildData:  val.blockData,
+		Phase:      MsgTypeCommitPrepare,
+		ValSetHash: val.valSetHash,
+	})
 }
 
 func (val *Validator) logMessageVerificationFailure(msg *Msg) {
-	val.log.Print("Message verification failed.")
-	val.log.Print("@", msg.blockHeight)
-	val.log.Print("#", msg.hash)
-	val.log.Print("P#", getNoncedHash(msg.hash, NoncePrepare))
-	val.log.Print("C#", getNoncedHash(msg.hash, NonceCommit))
-	val.log.Print("Self#", val.hash)
-	val.log.Print("PSig:", msg.PSig.counters, "(", msg.PSig.sig, ")")
-	val.log.Print("PSig sig pairing:", val.bls.PairSig(msg.PSig.sig))
-	val.log.Print("PSig agg pubkey:", msg.PSig.computeAggKey(val.bls, val.valPubKeySet))
-	val.log.Print("CSig:", msg.CSig.counters, "(", msg.CSig.sig, ")")
-	val.log.Print("CSig sig pairing:", val.bls.PairSig(msg.CSig.sig))
-	val.log.Print("CSig agg pubkey:", msg.CSig.computeAggKey(val.bls, val.valPubKeySet))
+	val.log.WithFields(logrus.Fields{
+		"height":    msg.blockHeight,
+		"msgType":   MsgTypeName(msg.msgType),
+		"hash":      hex.EncodeToString(msg.hash),
+		"selfHash":  hex.EncodeToString(val.hash),
+		"pCounters": msg.PSig.counters,
+		"cCounters": msg.CSig.counters,
+	}).Error("Message verification failed.")
+	if !val.log.IsLevelEnabled(logrus.DebugLevel) {
+		return
+	}
+	val.log.WithFields(logrus.Fields{
+		"pSig":        msg.PSig.sig,
+		"pSigPairing": val.bls.PairSig(msg.PSig.sig),
+		"pAggPubKey":  msg.PSig.computeAggKey(val.bls, val.valPubKeySet),
+		"cSig":        msg.CSig.sig,
+		"cSigPairing": val.bls.PairSig(msg.CSig.sig),
+		"cAggPubKey":  msg.CSig.computeAggKey(val.bls, val.valPubKeySet),
+	}).Debug("Failed message signatures")
 }
 
 func getProposerID(blockHeight uint64, numVals int) int {